@@ -0,0 +1,68 @@
+// Package apierror writes the JSON error envelope shared by every endpoint:
+//
+//	{"error": {"code": "not_found", "message": "task not found", "fields": {...}}}
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Response is the body of an error response
+type Response struct {
+	Error Body `json:"error"`
+}
+
+// Body describes the error, Code is stable for clients to branch on and
+// Fields has a message per invalid field of the request
+type Body struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Write answers with the envelope
+func Write(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{Error: Body{Code: code, Message: message, Fields: fields}})
+}
+
+// Error answers like http.Error with the envelope, the code is derived from
+// the status
+func Error(w http.ResponseWriter, message string, status int) {
+	Write(w, status, Code(status), message, nil)
+}
+
+// Code returns the generic code of a status
+func Code(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusPreconditionFailed:
+		return "precondition_failed"
+	case http.StatusRequestEntityTooLarge:
+		return "payload_too_large"
+	case http.StatusUnprocessableEntity:
+		return "validation_failed"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	if status >= http.StatusInternalServerError {
+		return "internal"
+	}
+	return "error"
+}
@@ -1,66 +1,235 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"belykh-ik/taskflow/apierror"
+	appconfig "belykh-ik/taskflow/config"
 	"belykh-ik/taskflow/database"
 	"belykh-ik/taskflow/handlers"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/mail"
+	"belykh-ik/taskflow/metrics"
 	"belykh-ik/taskflow/middleware"
 	"belykh-ik/taskflow/models"
 	"belykh-ik/taskflow/service"
+	"belykh-ik/taskflow/tracing"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
 func main() {
 	// Load environment variables
 	err := godotenv.Load()
 	if err != nil {
-		log.Println("Warning: .env file not found")
+		slog.Warn(".env file not found")
 	}
 
-	config := &models.Config{
-		DSN:        os.Getenv("DATABASE_URL"),
-		PORT:       os.Getenv("PORT"),
-		JWT_SECRET: []byte(os.Getenv("JWT_SECRET")),
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(configCommand(args[1:]))
+	}
+	migrateOnly := len(args) > 0 && args[0] == "migrate"
+	if migrateOnly {
+		args = args[1:]
+	}
+
+	config, err := appconfig.Load(args)
+	if err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
+	if err := logging.Setup(config.Log.Level, config.Log.Format); err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(config.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
 	}
 
 	// Connect to PostgreSQL
-	db := database.ConnectDb(config.DSN)
+	db := database.ConnectDb(config.Database)
 	defer db.Close()
 
+	// "migrate" applies the pending migrations and exits, for deployments
+	// that migrate in a separate step with DB_AUTO_MIGRATE=false
+	if migrateOnly || config.Database.AutoMigrate {
+		if err := database.Migrate(context.Background(), db); err != nil {
+			logging.Fatal("Failed to migrate database", "err", err)
+		}
+		if migrateOnly {
+			return
+		}
+	}
+
 	// Initialize router
 	r := mux.NewRouter()
+	r.Use(otelmux.Middleware(config.Tracing.ServiceName))
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	})
 
 	// Create Deps
 	board := service.NewBoardDeps(db)
 	task := service.NewTaskDeps(db)
 	user := service.NewUserDeps(db)
 	notification := service.NewNotificationDeps(db)
+	tokens := service.NewTokenDeps(db)
+	members := service.NewMemberDeps(db, config.DefaultBoardRole)
+	oidc := service.NewOIDCDeps(db, &config.OIDC, members)
+	twoFactor := service.NewTwoFactorDeps(db)
+	lockout := service.NewLockoutDeps(db, config.Lockout)
+	mailer := mail.NewSender(config.Mail.Addr, config.Mail.From, config.Mail.Username, config.Mail.Password)
+	account := service.NewAccountDeps(db, mailer, config.AppURL)
+	invitations := service.NewInvitationDeps(db, mailer, config.AppURL, members)
+	privacy := service.NewPrivacyDeps(db)
+	roles := service.NewRoleDeps(db)
+	orgs := service.NewOrgDeps(db, roles)
+	groups := service.NewGroupDeps(db)
+	filters := service.NewFilterDeps(db)
+	idempotency := service.NewIdempotencyDeps(db, config.Server.IdempotencyKeyTTL)
+	authz := middleware.NewAuth(config, tokens, roles, user)
+
+	bootstrapAdmin(config, invitations)
 
 	// Register Routes
-	handlers.RegisterRoures(r, db, config, board, task, user, notification)
-	handlers.RegisterAuthRoures(r, db, config)
+	metrics.Register(db)
+	r.Handle("/metrics", metrics.Handler(config.MetricsToken)).Methods("GET")
+	health := handlers.RegisterHealthRoutes(r, db)
+	handlers.RegisterRoures(r, db, config, authz, board, task, user, notification, twoFactor, lockout, roles, members, orgs, groups, invitations, privacy, filters, idempotency)
+	handlers.RegisterAuthRoures(r, db, config, authz, tokens, oidc, twoFactor, account, lockout, members, orgs, invitations, privacy)
 
-	// Add Server Port
-	port := config.PORT
-	if port == "" {
-		port = "8080"
-	}
+	// Middlewares, the request logger is the outermost so every response
+	// carries a request id and gets an access log line
+	var handler http.Handler = metrics.Instrument(r)
+	handler = middleware.NewRateLimiter(config.RateLimits.Global, config.ProxyHops).LimitIP(handler)
+	handler = middleware.MaxBody(config.Server.MaxBodyBytes)(handler)
+	handler = middleware.NewCors(config.CORS.AllowedOrigins)(handler)
+	handler = middleware.RequestLogger(handler)
 
 	//Create Server
 	server := http.Server{
-		Addr:    ":" + port,
-		Handler: middleware.Cors(r),
+		Addr:              net.JoinHostPort(config.Server.Host, strconv.Itoa(config.Server.Port)),
+		Handler:           handler,
+		ReadTimeout:       config.Server.ReadTimeout,
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+	}
+
+	if config.Server.TLS.Enabled() {
+		certs, err := appconfig.NewCertReloader(config.Server.TLS)
+		if err != nil {
+			logging.Fatal("Failed to load TLS certificate", "err", err)
+		}
+		server.TLSConfig = certs.TLSConfig()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			slog.Info("Server is listening with TLS", "addr", server.Addr)
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("Server is listening", "addr", server.Addr)
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	// Drain on SIGTERM or SIGINT: /readyz starts failing, new connections are
+	// refused and in-flight requests get the shutdown timeout to finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server error", "err", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", config.Server.ShutdownTimeout.String())
+	health.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Shutdown timed out, closing remaining connections", "err", err)
+		server.Close()
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("Failed to flush spans", "err", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// configCommand runs "config print", which shows the effective settings with
+// secrets masked and reports invalid ones
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: taskflow config print [flags]")
+		return 2
+	}
+	values, err := appconfig.Resolve(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	appconfig.Print(os.Stdout, values)
+	if _, err := appconfig.Parse(values); err != nil {
+		fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%v\n", err)
+		return 1
 	}
+	return 0
+}
 
-	log.Printf("Server is listening on port %s...", port)
-	err = server.ListenAndServe()
+// bootstrapAdmin makes sure the first super-admin can be created. It is
+// created from the BOOTSTRAP_ADMIN_* settings when they are set, otherwise a
+// one-time setup token for /api/auth/bootstrap is written to the log
+func bootstrapAdmin(config *models.Config, invitations *service.InvitationDeps) {
+	ctx := context.Background()
+	needed, err := invitations.NeedsBootstrap(ctx)
 	if err != nil {
-		fmt.Printf("Error %s", err)
+		logging.Fatal("Failed to check for an administrator", "err", err)
+	}
+	if !needed {
+		return
+	}
+
+	admin := config.BootstrapAdmin
+	if admin.Email != "" && admin.Password != "" {
+		user, err := invitations.BootstrapAdmin(ctx, admin.Username, admin.Email, admin.Password)
+		if err != nil && !errors.Is(err, service.ErrAlreadyBootstrapped) {
+			logging.Fatal("Failed to create the administrator", "err", err)
+		}
+		if user != nil {
+			slog.Info("Created administrator", "email", user.Email)
+		}
+		return
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		logging.Fatal("Failed to generate the setup token", "err", err)
 	}
+	config.BootstrapToken = hex.EncodeToString(raw)
+	slog.Warn("No administrator yet, create one with POST /api/auth/bootstrap using the setup token", "setup_token", config.BootstrapToken)
 }
@@ -0,0 +1,549 @@
+// Package config builds the server configuration from defaults, an optional
+// JSON file, environment variables and command-line flags, in increasing
+// order of precedence
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"belykh-ik/taskflow/models"
+)
+
+// setting is a configuration key. The key is the environment variable, the
+// config file uses the same names and the flag is the lowercase key with
+// dashes, e.g. --read-timeout
+type setting struct {
+	key      string
+	fallback string
+	secret   bool
+	usage    string
+}
+
+var settings = []setting{
+	{"DATABASE_URL", "", true, "PostgreSQL connection string"},
+	{"DB_MAX_OPEN_CONNS", "25", false, "maximum open database connections, 0 for unlimited"},
+	{"DB_MAX_IDLE_CONNS", "25", false, "maximum idle database connections"},
+	{"DB_CONN_MAX_LIFETIME", "30m", false, "maximum lifetime of a database connection, 0 keeps them forever"},
+	{"DB_CONN_MAX_IDLE_TIME", "5m", false, "maximum idle time of a database connection, 0 keeps them forever"},
+	{"DB_CONNECT_TIMEOUT", "1m", false, "how long startup waits for the database"},
+	{"DB_AUTO_MIGRATE", "true", false, "apply pending database migrations at startup, otherwise run the migrate command"},
+
+	{"HOST", "", false, "address to listen on, empty for all interfaces"},
+	{"PORT", "8080", false, "port to listen on"},
+	{"READ_TIMEOUT", "15s", false, "maximum duration for reading a request"},
+	{"READ_HEADER_TIMEOUT", "5s", false, "maximum duration for reading request headers"},
+	{"WRITE_TIMEOUT", "30s", false, "maximum duration for writing a response"},
+	{"IDLE_TIMEOUT", "2m", false, "how long keep-alive connections stay open"},
+	{"REQUEST_TIMEOUT", "10s", false, "deadline of a request including its database queries, 0 disables it"},
+	{"SLOW_REQUEST_TIMEOUT", "25s", false, "deadline of board loads and data exports, 0 disables it"},
+	{"MAX_BODY_SIZE", "1MB", false, "maximum request body size (B, KB, MB), 0 for unlimited"},
+	{"MAX_HEADER_SIZE", "64KB", false, "maximum request header size (B, KB, MB)"},
+	{"SHUTDOWN_TIMEOUT", "30s", false, "how long in-flight requests get to finish on shutdown"},
+	{"IDEMPOTENCY_KEY_TTL", "24h", false, "how long responses of requests with an Idempotency-Key are replayed to retries"},
+	{"TLS_CERT_FILE", "", false, "certificate file, serves HTTPS together with TLS_KEY_FILE"},
+	{"TLS_KEY_FILE", "", false, "private key file of the certificate"},
+	{"TLS_RELOAD_INTERVAL", "0", false, "how often the certificate files are checked for changes, 0 disables reloading"},
+	{"CORS_ALLOWED_ORIGINS", "http://localhost:3000", false, "comma separated origins allowed to call the API, * for any"},
+
+	{"JWT_SECRET", "", true, "secret signing session tokens, at least 32 bytes"},
+	{"JWT_TTL", "24h", false, "lifetime of session tokens"},
+	{"LOG_LEVEL", "info", false, "minimum log level: debug, info, warn or error"},
+	{"LOG_FORMAT", "json", false, "log output: json or text"},
+	{"METRICS_TOKEN", "", true, "bearer token required on /metrics, empty leaves it open"},
+	{"TRACING_EXPORTER", "none", false, "where spans are sent: none, stdout or otlp"},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318", false, "OTLP/HTTP collector receiving the spans"},
+	{"OTEL_SERVICE_NAME", "taskflow", false, "service name attached to the spans"},
+	{"TRACING_SAMPLE_RATIO", "1", false, "share of new traces that are recorded, between 0 and 1"},
+
+	{"PASSWORD_LOGIN_DISABLED", "false", false, "allow single sign-on only"},
+	{"REQUIRE_VERIFIED_EMAIL", "false", false, "refuse password login until the email is verified"},
+	{"APP_URL", "", false, "frontend URL used in emailed links"},
+	{"SMTP_ADDR", "", false, "SMTP server host:port, emails are logged when empty"},
+	{"SMTP_FROM", "", false, "sender of outgoing emails"},
+	{"SMTP_USERNAME", "", false, "SMTP username"},
+	{"SMTP_PASSWORD", "", true, "SMTP password"},
+	{"OIDC_ISSUER", "", false, "OpenID Connect issuer, empty disables single sign-on"},
+	{"OIDC_CLIENT_ID", "", false, "OpenID Connect client id"},
+	{"OIDC_CLIENT_SECRET", "", true, "OpenID Connect client secret"},
+	{"OIDC_REDIRECT_URL", "", false, "backend callback registered at the provider"},
+	{"OIDC_FRONTEND_URL", "", false, "frontend page receiving the token after single sign-on"},
+	{"OIDC_ROLE_CLAIM", "", false, "ID token claim used for role mapping"},
+	{"OIDC_ROLE_MAPPING", "", false, "comma separated claimValue=role pairs, first match wins"},
+	{"OIDC_DEFAULT_ROLE", "", false, "role of new single sign-on users without a mapping, existing users keep theirs"},
+	{"TRUST_PROXY", "false", false, "take the client IP from X-Forwarded-For"},
+	{"TRUSTED_PROXY_HOPS", "1", false, "reverse proxies in front of the server appending to X-Forwarded-For, with TRUST_PROXY"},
+	{"RATE_LIMIT_GLOBAL", "600/m", false, "per IP limit on every request"},
+	{"RATE_LIMIT_AUTH", "10/m", false, "limit on login, registration and password endpoints"},
+	{"RATE_LIMIT_BOARD", "60/m", false, "per user limit on the board"},
+	{"RATE_LIMIT_API", "300/m", false, "per user limit on the other endpoints"},
+	{"LOGIN_MAX_ATTEMPTS", "5", false, "failed logins before a lockout"},
+	{"LOGIN_LOCKOUT_BASE", "1m", false, "first lockout delay, doubled with each lockout"},
+	{"LOGIN_LOCKOUT_MAX", "1h", false, "maximum lockout delay"},
+	{"DEFAULT_BOARD_ROLE", models.BoardRoleEditor, false, "role new users get on the default board, none to require an invitation"},
+	{"OPEN_REGISTRATION", "true", false, "let anyone sign up, otherwise accounts come from invitations"},
+	{"BOOTSTRAP_ADMIN_USERNAME", "admin", false, "username of the first super-admin"},
+	{"BOOTSTRAP_ADMIN_EMAIL", "", false, "email of the first super-admin"},
+	{"BOOTSTRAP_ADMIN_PASSWORD", "", true, "password of the first super-admin"},
+}
+
+// Value is the effective value of a setting and where it came from
+type Value struct {
+	Key    string
+	Value  string
+	Source string
+	Secret bool
+}
+
+// Values holds the effective settings in declaration order
+type Values []Value
+
+func (v Values) get(key string) string {
+	for _, value := range v {
+		if value.Key == key {
+			return value.Value
+		}
+	}
+	return ""
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Resolve reads the settings from the defaults, the config file given by
+// --config or CONFIG_FILE, the environment and the flags in args
+func Resolve(args []string) (Values, error) {
+	fs := flag.NewFlagSet("taskflow", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file, keys are the environment variable names")
+	flags := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flags[s.key] = fs.String(flagName(s.key), "", s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	fileValues := map[string]string{}
+	if *file != "" {
+		var err error
+		if fileValues, err = readFile(*file); err != nil {
+			return nil, err
+		}
+	}
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	values := make(Values, 0, len(settings))
+	for _, s := range settings {
+		value := Value{Key: s.key, Value: s.fallback, Source: "default", Secret: s.secret}
+		if v, ok := fileValues[s.key]; ok {
+			value.Value, value.Source = v, *file
+		}
+		if v, ok := os.LookupEnv(s.key); ok && v != "" {
+			value.Value, value.Source = v, "env"
+		}
+		if setFlags[flagName(s.key)] {
+			value.Value, value.Source = *flags[s.key], "flag"
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// readFile reads a JSON object of settings. Lists may be given as arrays and
+// numbers or booleans without quotes
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		key = strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if !known[key] {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("config file %s: invalid value for %s", path, key)
+		}
+	}
+	return values, nil
+}
+
+// Load resolves the settings and builds the validated configuration
+func Load(args []string) (*models.Config, error) {
+	values, err := Resolve(args)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(values)
+}
+
+// Parse builds the configuration from the settings, reporting every invalid
+// setting at once
+func Parse(values Values) (*models.Config, error) {
+	p := &parser{values: values}
+	config := &models.Config{
+		Database: models.DatabaseConfig{
+			DSN:             p.str("DATABASE_URL"),
+			MaxOpenConns:    p.count("DB_MAX_OPEN_CONNS"),
+			MaxIdleConns:    p.count("DB_MAX_IDLE_CONNS"),
+			ConnMaxLifetime: p.duration("DB_CONN_MAX_LIFETIME"),
+			ConnMaxIdleTime: p.duration("DB_CONN_MAX_IDLE_TIME"),
+			ConnectTimeout:  p.duration("DB_CONNECT_TIMEOUT"),
+			AutoMigrate:     p.bool("DB_AUTO_MIGRATE"),
+		},
+		Server: models.ServerConfig{
+			Host:               p.str("HOST"),
+			Port:               p.port("PORT"),
+			ReadTimeout:        p.duration("READ_TIMEOUT"),
+			ReadHeaderTimeout:  p.duration("READ_HEADER_TIMEOUT"),
+			WriteTimeout:       p.duration("WRITE_TIMEOUT"),
+			IdleTimeout:        p.duration("IDLE_TIMEOUT"),
+			RequestTimeout:     p.duration("REQUEST_TIMEOUT"),
+			SlowRequestTimeout: p.duration("SLOW_REQUEST_TIMEOUT"),
+			MaxBodyBytes:       p.size("MAX_BODY_SIZE"),
+			MaxHeaderBytes:     int(p.size("MAX_HEADER_SIZE")),
+			ShutdownTimeout:    p.duration("SHUTDOWN_TIMEOUT"),
+			IdempotencyKeyTTL:  p.duration("IDEMPOTENCY_KEY_TTL"),
+			TLS: models.TLSConfig{
+				CertFile:       p.str("TLS_CERT_FILE"),
+				KeyFile:        p.str("TLS_KEY_FILE"),
+				ReloadInterval: p.duration("TLS_RELOAD_INTERVAL"),
+			},
+		},
+		CORS: models.CORSConfig{
+			AllowedOrigins: p.origins("CORS_ALLOWED_ORIGINS"),
+		},
+		JWT: models.JWTConfig{
+			Secret:   []byte(p.str("JWT_SECRET")),
+			Lifetime: p.duration("JWT_TTL"),
+		},
+		Log: models.LogConfig{
+			Level:  p.str("LOG_LEVEL"),
+			Format: p.str("LOG_FORMAT"),
+		},
+		Tracing: models.TracingConfig{
+			Exporter:    strings.ToLower(p.str("TRACING_EXPORTER")),
+			Endpoint:    p.str("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			ServiceName: p.str("OTEL_SERVICE_NAME"),
+			SampleRatio: p.ratio("TRACING_SAMPLE_RATIO"),
+		},
+		MetricsToken:          p.str("METRICS_TOKEN"),
+		PasswordLoginDisabled: p.bool("PASSWORD_LOGIN_DISABLED"),
+		RequireVerifiedEmail:  p.bool("REQUIRE_VERIFIED_EMAIL"),
+		AppURL:                p.str("APP_URL"),
+		Mail: models.MailConfig{
+			Addr:     p.str("SMTP_ADDR"),
+			From:     p.str("SMTP_FROM"),
+			Username: p.str("SMTP_USERNAME"),
+			Password: p.str("SMTP_PASSWORD"),
+		},
+		OIDC: models.OIDCConfig{
+			Issuer:       p.str("OIDC_ISSUER"),
+			ClientID:     p.str("OIDC_CLIENT_ID"),
+			ClientSecret: p.str("OIDC_CLIENT_SECRET"),
+			RedirectURL:  p.str("OIDC_REDIRECT_URL"),
+			FrontendURL:  p.str("OIDC_FRONTEND_URL"),
+			RoleClaim:    p.str("OIDC_ROLE_CLAIM"),
+			RoleMapping:  parseRoleMapping(p.str("OIDC_ROLE_MAPPING")),
+			DefaultRole:  p.str("OIDC_DEFAULT_ROLE"),
+		},
+		ProxyHops: p.proxyHops("TRUST_PROXY", "TRUSTED_PROXY_HOPS"),
+		RateLimits: models.RateLimitConfig{
+			Global: p.rateLimit("RATE_LIMIT_GLOBAL"),
+			Auth:   p.rateLimit("RATE_LIMIT_AUTH"),
+			Board:  p.rateLimit("RATE_LIMIT_BOARD"),
+			API:    p.rateLimit("RATE_LIMIT_API"),
+		},
+		Lockout: models.LockoutConfig{
+			MaxAttempts: p.count("LOGIN_MAX_ATTEMPTS"),
+			BaseDelay:   p.duration("LOGIN_LOCKOUT_BASE"),
+			MaxDelay:    p.duration("LOGIN_LOCKOUT_MAX"),
+		},
+		DefaultBoardRole: p.boardRole("DEFAULT_BOARD_ROLE"),
+		OpenRegistration: p.bool("OPEN_REGISTRATION"),
+		BootstrapAdmin: models.BootstrapAdminConfig{
+			Username: p.str("BOOTSTRAP_ADMIN_USERNAME"),
+			Email:    p.str("BOOTSTRAP_ADMIN_EMAIL"),
+			Password: p.str("BOOTSTRAP_ADMIN_PASSWORD"),
+		},
+	}
+
+	if config.Database.DSN == "" {
+		p.fail("DATABASE_URL", "is required")
+	}
+	if len(config.JWT.Secret) < 32 {
+		p.fail("JWT_SECRET", "must be at least 32 bytes")
+	}
+	if config.JWT.Lifetime <= 0 {
+		p.fail("JWT_TTL", "must be positive")
+	}
+	if (config.Server.TLS.CertFile == "") != (config.Server.TLS.KeyFile == "") {
+		p.fail("TLS_CERT_FILE", "and TLS_KEY_FILE must be set together")
+	}
+	// A deadline past the write timeout would only be noticed once the
+	// response can't be sent anymore
+	if write := config.Server.WriteTimeout; write > 0 {
+		if config.Server.RequestTimeout == 0 || config.Server.RequestTimeout > write {
+			p.fail("REQUEST_TIMEOUT", "must be set and not exceed WRITE_TIMEOUT (%s)", write)
+		}
+		if config.Server.SlowRequestTimeout == 0 || config.Server.SlowRequestTimeout > write {
+			p.fail("SLOW_REQUEST_TIMEOUT", "must be set and not exceed WRITE_TIMEOUT (%s)", write)
+		}
+	}
+	if config.Server.MaxHeaderBytes <= 0 {
+		p.fail("MAX_HEADER_SIZE", "must be positive")
+	}
+	if config.Server.IdempotencyKeyTTL <= 0 {
+		p.fail("IDEMPOTENCY_KEY_TTL", "must be positive")
+	}
+	switch strings.ToLower(config.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		p.fail("LOG_LEVEL", "must be debug, info, warn or error, got %q", config.Log.Level)
+	}
+	switch strings.ToLower(config.Log.Format) {
+	case "json", "text":
+	default:
+		p.fail("LOG_FORMAT", "must be json or text, got %q", config.Log.Format)
+	}
+	switch config.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		p.fail("TRACING_EXPORTER", "must be none, stdout or otlp, got %q", config.Tracing.Exporter)
+	}
+	if config.Tracing.Exporter == "otlp" {
+		if u, err := url.Parse(config.Tracing.Endpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			p.fail("OTEL_EXPORTER_OTLP_ENDPOINT", "must be an http or https URL, got %q", config.Tracing.Endpoint)
+		}
+	}
+	if len(p.errs) > 0 {
+		return nil, errors.Join(p.errs...)
+	}
+	return config, nil
+}
+
+// Print writes the effective settings and their source, secrets are masked
+func Print(w io.Writer, values Values) {
+	for _, v := range values {
+		value := v.Value
+		if v.Secret && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(w, "%s=%q\t# %s\n", v.Key, value, v.Source)
+	}
+}
+
+// parser converts settings and collects the errors
+type parser struct {
+	values Values
+	errs   []error
+}
+
+func (p *parser) fail(key, format string, args ...interface{}) {
+	p.errs = append(p.errs, fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (p *parser) str(key string) string {
+	return strings.TrimSpace(p.values.get(key))
+}
+
+func (p *parser) bool(key string) bool {
+	value := p.str(key)
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(key, "must be true or false, got %q", value)
+	}
+	return b
+}
+
+// count parses a non-negative integer
+func (p *parser) count(key string) int {
+	value := p.str(key)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		p.fail(key, "must be a non-negative number, got %q", value)
+	}
+	return n
+}
+
+// proxyHops returns the number of trusted proxies, 0 unless the proxy is trusted
+func (p *parser) proxyHops(trustKey, hopsKey string) int {
+	value := p.str(hopsKey)
+	hops, err := strconv.Atoi(value)
+	if err != nil || hops < 1 {
+		p.fail(hopsKey, "must be a positive number, got %q", value)
+	}
+	if !p.bool(trustKey) {
+		return 0
+	}
+	return hops
+}
+
+func (p *parser) port(key string) int {
+	value := p.str(key)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 65535 {
+		p.fail(key, "must be a port number, got %q", value)
+	}
+	return n
+}
+
+// ratio parses a fraction between 0 and 1
+func (p *parser) ratio(key string) float64 {
+	value := p.str(key)
+	r, err := strconv.ParseFloat(value, 64)
+	if err != nil || r < 0 || r > 1 {
+		p.fail(key, "must be a number between 0 and 1, got %q", value)
+	}
+	return r
+}
+
+// duration parses a non-negative Go duration, a bare 0 is allowed
+func (p *parser) duration(key string) time.Duration {
+	value := p.str(key)
+	if value == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		p.fail(key, "must be a duration like 30s, got %q", value)
+	}
+	return d
+}
+
+// size parses a byte count with an optional B, KB or MB suffix
+func (p *parser) size(key string) int64 {
+	value := strings.ToUpper(p.str(key))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		p.fail(key, "must be a size like 1MB, got %q", p.str(key))
+	}
+	return n * multiplier
+}
+
+// origins parses the allowed CORS origins, each is "*" or scheme://host[:port]
+func (p *parser) origins(key string) []string {
+	var origins []string
+	for _, origin := range strings.Split(p.str(key), ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				p.fail(key, "has an invalid origin %q, expected scheme://host[:port]", origin)
+				continue
+			}
+		}
+		origins = append(origins, origin)
+	}
+	return origins
+}
+
+// rateLimit parses "requests/period" such as "10/m", the burst equals
+// the request count. "0" or "off" disables the limit
+func (p *parser) rateLimit(key string) models.RateLimit {
+	value := p.str(key)
+	if value == "0" || value == "off" {
+		return models.RateLimit{}
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		p.fail(key, "must be requests/period like 10/m, got %q", value)
+		return models.RateLimit{}
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		p.fail(key, "must be requests/period like 10/m, got %q", value)
+		return models.RateLimit{}
+	}
+
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[parts[1]]
+	if !ok {
+		period, err = time.ParseDuration(parts[1])
+		if err != nil || period <= 0 {
+			p.fail(key, "must be requests/period like 10/m, got %q", value)
+			return models.RateLimit{}
+		}
+	}
+	return models.RateLimit{
+		Rate:  float64(requests) / period.Seconds(),
+		Burst: requests,
+	}
+}
+
+// boardRole parses the role new users get on the default board, "none"
+// keeps them off it
+func (p *parser) boardRole(key string) string {
+	value := p.str(key)
+	if value == "none" {
+		return ""
+	}
+	if !models.IsBoardRole(value) {
+		p.fail(key, "must be a board role or none, got %q", value)
+	}
+	return value
+}
+
+// parseRoleMapping parses "claimValue=role" pairs separated by commas
+func parseRoleMapping(value string) []models.OIDCRoleMapping {
+	var mapping []models.OIDCRoleMapping
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		mapping = append(mapping, models.OIDCRoleMapping{Value: parts[0], Role: parts[1]})
+	}
+	return mapping
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"belykh-ik/taskflow/models"
+)
+
+// CertReloader serves the certificate of the TLS settings and picks up renewed
+// files, so certificates can be rotated without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader loads the certificate, failing when the files are invalid
+func NewCertReloader(conf models.TLSConfig) (*CertReloader, error) {
+	c := &CertReloader{
+		certFile: conf.CertFile,
+		keyFile:  conf.KeyFile,
+		interval: conf.ReloadInterval,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TLSConfig returns a TLS configuration using the reloaded certificate
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate, reloading it when the
+// files changed since the last check
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c.interval > 0 {
+		c.mu.RLock()
+		due := time.Since(c.checked) >= c.interval
+		c.mu.RUnlock()
+		if due {
+			c.reload()
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reload loads the files again when they were modified, a broken renewal
+// keeps the previous certificate
+func (c *CertReloader) reload() {
+	c.mu.Lock()
+	c.checked = time.Now()
+	c.mu.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		slog.Error("Error checking TLS certificate", "err", err)
+		return
+	}
+	c.mu.RLock()
+	changed := modTime.After(c.modTime)
+	c.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := c.load(); err != nil {
+		slog.Error("Error reloading TLS certificate, keeping the previous one", "err", err)
+		return
+	}
+	slog.Info("Reloaded TLS certificate", "file", c.certFile)
+}
+
+func (c *CertReloader) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.checked = time.Now()
+	return nil
+}
+
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -2,22 +2,59 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/models"
+
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const (
+	connectBaseDelay = 500 * time.Millisecond
+	connectMaxDelay  = 15 * time.Second
 )
 
-func ConnectDb(DSN string) *sql.DB {
-	db, err := sql.Open("postgres", DSN)
+// ConnectDb opens the connection pool and waits for PostgreSQL with an
+// exponential backoff, giving up after conf.ConnectTimeout. Statements run
+// with a traced context get a span each
+func ConnectDb(conf models.DatabaseConfig) *sql.DB {
+	db, err := otelsql.Open("postgres", conf.DSN,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		logging.Fatal("Failed to open database", "err", err)
 	}
-	time.Sleep(3 * time.Second)
-	err = db.Ping()
-	if err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+
+	deadline := time.Now().Add(conf.ConnectTimeout)
+	delay := connectBaseDelay
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			break
+		}
+		if time.Now().Add(delay).After(deadline) {
+			logging.Fatal("Failed to ping database", "attempts", attempt, "err", err)
+		}
+		slog.Warn("Database not reachable yet, retrying", "delay", delay.String(), "err", err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > connectMaxDelay {
+			delay = connectMaxDelay
+		}
 	}
-	log.Println("Connected to PostgreSQL database")
+	slog.Info("Connected to PostgreSQL database")
 	return db
 }
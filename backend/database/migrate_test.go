@@ -0,0 +1,22 @@
+package database
+
+import "testing"
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Versions are contiguous so a missing file is noticed
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d_%s, want version %d", m.version, m.name, i+1)
+		}
+		if m.sql == "" {
+			t.Errorf("migration %d_%s is empty", m.version, m.name)
+		}
+	}
+	if SchemaVersion() != len(migrations) {
+		t.Errorf("SchemaVersion = %d, want %d", SchemaVersion(), len(migrations))
+	}
+}
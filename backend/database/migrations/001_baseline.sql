@@ -1,3 +1,6 @@
+-- The original schema. Databases created before migrations already have it,
+-- so every statement leaves existing tables and rows alone
+
 -- Create users table
 CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -55,17 +58,19 @@ CREATE TABLE IF NOT EXISTS notifications (
 );
 
 -- Insert default board columns
-INSERT INTO board_columns (id, title, column_order) VALUES
+INSERT INTO board_columns (id, title, column_order)
+SELECT c.id, c.title, c.column_order
+FROM (VALUES
     ('backlog', 'Бэклог', 1),
     ('inprogress', 'В работе', 2),
     ('aprove', 'На подтверждении', 3),
-    ('done', 'Завершено', 4);
+    ('done', 'Завершено', 4)
+) AS c(id, title, column_order)
+WHERE NOT EXISTS (SELECT 1 FROM board_columns);
 
 -- Insert default board config
-INSERT INTO board_config (column_order) VALUES ('["backlog", "inprogress", "aprove", "done"]')
+INSERT INTO board_config (id, column_order) VALUES (1, '["backlog", "inprogress", "aprove", "done"]')
 ON CONFLICT (id) DO NOTHING;
 
--- Create default admin user (username: admin, password: admin)
-INSERT INTO users (username, email, password, role) 
-VALUES ('admin', 'admin@example.com', 'admin', 'admin')
-ON CONFLICT (email) DO NOTHING;
\ No newline at end of file
+-- The default admin/admin user of the original schema is not created, the
+-- first super-admin comes from BOOTSTRAP_ADMIN_* or /api/auth/bootstrap
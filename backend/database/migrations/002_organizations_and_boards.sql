@@ -0,0 +1,250 @@
+-- Organizations, roles, per-board membership, groups and the account
+-- features added since the original schema. Statements are written so they
+-- also run on databases that got some of these tables from schema.sql before
+-- migrations existed
+
+-- Create organizations table (tenants owning users, boards and settings)
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    settings JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Insert default organization, existing users and boards belong to it
+INSERT INTO organizations (id, name) VALUES (1, 'Default')
+ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('organizations', 'id'), (SELECT MAX(id) FROM organizations));
+
+-- Users belong to an organization, roles are permission sets now
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS org_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS is_super_admin BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255) UNIQUE,
+    ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_required BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS lockouts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP WITH TIME ZONE,
+    ALTER COLUMN role SET DEFAULT 'member';
+
+CREATE INDEX IF NOT EXISTS users_org_id_idx ON users(org_id);
+
+-- board_config holds one row per board, the original single board is the
+-- default board of the default organization
+CREATE SEQUENCE IF NOT EXISTS board_config_id_seq OWNED BY board_config.id;
+ALTER TABLE board_config
+    ALTER COLUMN id SET DEFAULT nextval('board_config_id_seq'),
+    ADD COLUMN IF NOT EXISTS org_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS name VARCHAR(255) NOT NULL DEFAULT 'Доска',
+    ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT false,
+    -- version is bumped by every change to the board, its tasks and members
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS created_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+INSERT INTO board_config (id, org_id, is_default, column_order) VALUES (1, 1, true, '["backlog", "inprogress", "aprove", "done"]')
+ON CONFLICT (id) DO NOTHING;
+UPDATE board_config SET is_default = true
+WHERE id = 1 AND org_id = 1 AND NOT EXISTS (SELECT 1 FROM board_config WHERE org_id = 1 AND is_default);
+SELECT setval('board_config_id_seq', (SELECT MAX(id) FROM board_config));
+
+-- Every organization has one default board
+CREATE UNIQUE INDEX IF NOT EXISTS board_config_org_default_idx ON board_config(org_id) WHERE is_default;
+
+-- Create groups table (teams of users of an organization, e.g. Backend or QA)
+CREATE TABLE IF NOT EXISTS groups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS groups_org_name_idx ON groups(org_id, lower(name));
+
+-- Create group_members table
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_id_idx ON group_members(user_id);
+
+-- Tasks belong to a board and a group, created_by is cleared when its user is deleted
+ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS board_id INTEGER NOT NULL DEFAULT 1 REFERENCES board_config(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS group_id UUID REFERENCES groups(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1,
+    DROP CONSTRAINT IF EXISTS tasks_created_by_fkey,
+    ADD CONSTRAINT tasks_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS tasks_board_id_idx ON tasks(board_id);
+CREATE INDEX IF NOT EXISTS tasks_group_id_idx ON tasks(group_id);
+CREATE INDEX IF NOT EXISTS tasks_created_by_idx ON tasks(created_by);
+
+-- Create task_assignees table (a task can have several assignees)
+CREATE TABLE IF NOT EXISTS task_assignees (
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (task_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS task_assignees_user_id_idx ON task_assignees(user_id);
+
+-- Comments of deleted users keep a NULL author
+ALTER TABLE comments
+    ALTER COLUMN author DROP NOT NULL,
+    DROP CONSTRAINT IF EXISTS comments_author_fkey,
+    ADD CONSTRAINT comments_author_fkey FOREIGN KEY (author) REFERENCES users(id) ON DELETE SET NULL;
+
+-- Columns belong to a board, their ids are unique per board
+ALTER TABLE board_columns
+    ADD COLUMN IF NOT EXISTS board_id INTEGER NOT NULL DEFAULT 1 REFERENCES board_config(id) ON DELETE CASCADE,
+    DROP CONSTRAINT IF EXISTS board_columns_pkey,
+    ADD PRIMARY KEY (board_id, id);
+
+-- Create board_members table (owner, editor, commenter or viewer of a board)
+CREATE TABLE IF NOT EXISTS board_members (
+    board_id INTEGER NOT NULL REFERENCES board_config(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'commenter', 'viewer')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (board_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS board_members_user_id_idx ON board_members(user_id);
+
+-- Create board_invitations table (pending invitations, matched to users by email)
+CREATE TABLE IF NOT EXISTS board_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    board_id INTEGER NOT NULL REFERENCES board_config(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'commenter', 'viewer')),
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS board_invitations_board_email_idx ON board_invitations(board_id, lower(email));
+
+-- Create api_tokens table (personal access tokens, only the SHA-256 hash is stored)
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    prefix VARCHAR(16) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens(user_id);
+
+-- Create recovery_codes table (one-time two-factor recovery codes, hashed)
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes(user_id);
+
+-- Create user_action_tokens table (password reset and email verification links, hashed)
+CREATE TABLE IF NOT EXISTS user_action_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_action_tokens_user_id_idx ON user_action_tokens(user_id);
+
+-- Create idempotency_keys table (responses of requests sent with an
+-- Idempotency-Key, replayed when the client retries; status 0 while the first
+-- request is running)
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    status INTEGER NOT NULL DEFAULT 0,
+    headers JSONB NOT NULL DEFAULT '{}',
+    body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
+
+-- Create saved_filters table (task queries saved by a user, shared ones are
+-- visible to the whole organization)
+CREATE TABLE IF NOT EXISTS saved_filters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    query TEXT NOT NULL,
+    shared BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS saved_filters_org_id_idx ON saved_filters(org_id);
+CREATE INDEX IF NOT EXISTS saved_filters_user_id_idx ON saved_filters(user_id);
+
+-- Create user_invitations table (emailed links creating an account with a preassigned role, hashed)
+CREATE TABLE IF NOT EXISTS user_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_invitations_org_id_idx ON user_invitations(org_id);
+
+-- Create roles table (named permission sets shared by all organizations, built-in roles can't be deleted)
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    built_in BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Insert built-in roles
+INSERT INTO roles (name, description, permissions, built_in) VALUES
+    ('viewer', 'Read-only access to the board', '{board.view,user.view}', true),
+    ('member', 'Moves and comments on tasks', '{board.view,user.view,task.move,task.comment}', true),
+    ('maintainer', 'Manages tasks and board columns', '{board.view,user.view,task.move,task.comment,task.create,task.update,task.delete,board.configure,group.manage}', true),
+    ('admin', 'Organization admin with full access to its users and boards', '{board.view,user.view,task.move,task.comment,task.create,task.update,task.delete,board.configure,board.manage,user.manage,group.manage,org.configure}', true),
+    ('user', 'Legacy role with member permissions', '{board.view,user.view,task.move,task.comment}', true)
+ON CONFLICT (name) DO NOTHING;
+
+-- Insert default board columns
+INSERT INTO board_columns (board_id, id, title, column_order) VALUES
+    (1, 'backlog', 'Бэклог', 1),
+    (1, 'inprogress', 'В работе', 2),
+    (1, 'aprove', 'На подтверждении', 3),
+    (1, 'done', 'Завершено', 4)
+ON CONFLICT (board_id, id) DO NOTHING;
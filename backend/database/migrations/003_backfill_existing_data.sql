@@ -0,0 +1,30 @@
+-- Moves the data of databases created from the original schema into the
+-- tables added by 002. New columns like org_id and board_id got their default
+-- for existing rows when they were added
+
+-- The single assignee of a task becomes its first entry in task_assignees
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'tasks' AND column_name = 'assignee'
+    ) THEN
+        INSERT INTO task_assignees (task_id, user_id)
+        SELECT id, assignee FROM tasks WHERE assignee IS NOT NULL
+        ON CONFLICT (task_id, user_id) DO NOTHING;
+
+        ALTER TABLE tasks DROP COLUMN assignee;
+    END IF;
+END $$;
+
+-- Users with a role that isn't defined get the member role
+UPDATE users SET role = 'member' WHERE role NOT IN (SELECT name FROM roles);
+
+-- Users without any board membership keep access to the default board of
+-- their organization, admins own it
+INSERT INTO board_members (board_id, user_id, role)
+SELECT b.id, u.id, CASE WHEN u.role = 'admin' THEN 'owner' ELSE 'editor' END
+FROM users u
+JOIN board_config b ON b.org_id = u.org_id AND b.is_default
+WHERE u.erased_at IS NULL AND NOT EXISTS (SELECT 1 FROM board_members m WHERE m.user_id = u.id)
+ON CONFLICT (board_id, user_id) DO NOTHING;
@@ -0,0 +1,4 @@
+-- Free-form labels of a task, filtered on by the task list
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS labels TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS tasks_labels_idx ON tasks USING GIN (labels);
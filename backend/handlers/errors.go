@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/service"
+)
+
+// writeError answers with the JSON error envelope. Domain errors of the
+// service layer get the status of their kind, anything else is a server error
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if domainErr, ok := service.AsError(err); ok {
+		// The message of wrapped errors carries the detail, e.g. the unknown
+		// permission
+		apierror.Write(w, domainErr.Kind.Status(), domainErr.Code, err.Error(), domainErr.Fields)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	serverError(w, r, err)
+}
+
+// writeLookupError answers a failed lookup, a missing row is reported as
+// notFoundErr
+func writeLookupError(w http.ResponseWriter, r *http.Request, err error, notFoundErr error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = notFoundErr
+	}
+	writeError(w, r, err)
+}
+
+// serverError logs an unexpected error with the request id and answers with a
+// generic message, the details stay in the log. Errors of requests that ran
+// past their deadline or whose client went away aren't server faults
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	switch r.Context().Err() {
+	case context.DeadlineExceeded:
+		logging.FromContext(r.Context()).Warn("request timed out", "method", r.Method, "path", r.URL.Path, "err", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "timeout", "Request timed out", nil)
+		return
+	case context.Canceled:
+		logging.FromContext(r.Context()).Info("request canceled by the client", "method", r.Method, "path", r.URL.Path)
+		apierror.Write(w, http.StatusServiceUnavailable, "canceled", "Request canceled", nil)
+		return
+	}
+	logging.FromContext(r.Context()).Error("request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// badJSON answers a request body that couldn't be decoded, the decoder error
+// is not echoed since it may quote the body
+func badJSON(w http.ResponseWriter, err error) {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		apierror.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	apierror.Write(w, http.StatusBadRequest, "invalid_json", "Invalid JSON", nil)
+}
+
+// decodeJSON decodes the request body into the request DTO, unknown fields
+// and values of the wrong type are reported per field
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(dst)
+	if err == nil {
+		return true
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		writeError(w, r, service.ValidationError(map[string]string{typeErr.Field: "must be " + jsonType(typeErr.Type)}))
+		return false
+	}
+	// The decoder has no error type for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		writeError(w, r, service.ValidationError(map[string]string{strings.Trim(field, `"`): "unknown field"}))
+		return false
+	}
+	badJSON(w, err)
+	return false
+}
+
+// jsonType names the JSON type expected for a Go type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
@@ -5,7 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
 	"belykh-ik/taskflow/middleware"
 	"belykh-ik/taskflow/models"
 	"belykh-ik/taskflow/service"
@@ -18,89 +23,399 @@ type handlerDeps struct {
 	task         *service.TaskDeps
 	user         *service.UserDeps
 	notification *service.NotificationsDeps
+	twoFactor    *service.TwoFactorDeps
+	lockout      *service.LockoutDeps
+	roles        *service.RoleDeps
+	members      *service.MemberDeps
+	orgs         *service.OrgDeps
+	groups       *service.GroupDeps
+	invitations  *service.InvitationDeps
+	privacy      *service.PrivacyDeps
+	filters      *service.FilterDeps
 	db           *sql.DB
 }
 
-func RegisterRoures(r *mux.Router, db *sql.DB, conf *models.Config, board *service.BoardDeps, task *service.TaskDeps, user *service.UserDeps, notification *service.NotificationsDeps) {
+func RegisterRoures(r *mux.Router, db *sql.DB, conf *models.Config, authz *middleware.Auth, board *service.BoardDeps, task *service.TaskDeps, user *service.UserDeps, notification *service.NotificationsDeps, twoFactor *service.TwoFactorDeps, lockout *service.LockoutDeps, roles *service.RoleDeps, members *service.MemberDeps, orgs *service.OrgDeps, groups *service.GroupDeps, invitations *service.InvitationDeps, privacy *service.PrivacyDeps, filters *service.FilterDeps, idempotency *service.IdempotencyDeps) {
 	handler := &handlerDeps{
 		board:        board,
 		task:         task,
 		user:         user,
 		notification: notification,
+		twoFactor:    twoFactor,
+		lockout:      lockout,
+		roles:        roles,
+		members:      members,
+		orgs:         orgs,
+		groups:       groups,
+		invitations:  invitations,
+		privacy:      privacy,
+		filters:      filters,
 		db:           db,
 	}
+	// Every authenticated route is limited per user, the board has its own
+	// limit since it is the most expensive endpoint
+	apiLimit := middleware.NewRateLimiter(conf.RateLimits.API, conf.ProxyHops).Limit
+	boardLimit := middleware.NewRateLimiter(conf.RateLimits.Board, conf.ProxyHops).Limit
+	// Requests get a deadline, board loads and exports run longer than the rest
+	timeout := middleware.Timeout(conf.Server.RequestTimeout)
+	slowTimeout := middleware.Timeout(conf.Server.SlowRequestTimeout)
+	// Creations can be retried safely with an Idempotency-Key
+	idempotent := middleware.Idempotent(idempotency)
+	auth := func(next http.HandlerFunc, permissions ...string) http.HandlerFunc {
+		return timeout(authz.Require(apiLimit(next), permissions...))
+	}
+	slowAuth := func(next http.HandlerFunc, permissions ...string) http.HandlerFunc {
+		return slowTimeout(authz.Require(apiLimit(next), permissions...))
+	}
+
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
 
-	// Board routes
-	api.HandleFunc("/board", middleware.AuthMiddleware(conf, handler.getBoardHandler)).Methods("GET")
-	api.HandleFunc("/board/columns", middleware.AuthMiddleware(conf, handler.updateBoardColumnsHandler)).Methods("PUT")
+	// Board routes, /board is the default board
+	api.HandleFunc("/boards", auth(handler.getBoardsHandler, models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/boards", auth(idempotent(handler.createBoardHandler), models.PermBoardConfigure)).Methods("POST")
+	api.HandleFunc("/board", slowAuth(boardLimit(handler.getBoardHandler), models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/boards/{boardId:[0-9]+}", slowAuth(boardLimit(handler.getBoardHandler), models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/board/columns", auth(handler.updateBoardColumnsHandler, models.PermBoardConfigure)).Methods("PUT")
+	api.HandleFunc("/boards/{boardId:[0-9]+}/columns", auth(handler.updateBoardColumnsHandler, models.PermBoardConfigure)).Methods("PUT")
+
+	// Board membership routes, managed by board owners
+	api.HandleFunc("/boards/{boardId:[0-9]+}/members", auth(handler.getBoardMembersHandler, models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/boards/{boardId:[0-9]+}/members/{userId}", auth(handler.updateBoardMemberHandler)).Methods("PATCH")
+	api.HandleFunc("/boards/{boardId:[0-9]+}/members/{userId}", auth(handler.removeBoardMemberHandler)).Methods("DELETE")
+	api.HandleFunc("/boards/{boardId:[0-9]+}/invitations", auth(handler.getBoardInvitationsHandler)).Methods("GET")
+	api.HandleFunc("/boards/{boardId:[0-9]+}/invitations", auth(handler.inviteBoardMemberHandler)).Methods("POST")
+	api.HandleFunc("/boards/{boardId:[0-9]+}/invitations/{id}", auth(handler.revokeBoardInvitationHandler)).Methods("DELETE")
+
+	// Invitations of the current user
+	api.HandleFunc("/boards/invitations", auth(handler.getMyBoardInvitationsHandler)).Methods("GET")
+	api.HandleFunc("/boards/invitations/{id}/accept", auth(handler.acceptBoardInvitationHandler)).Methods("POST")
+	api.HandleFunc("/boards/invitations/{id}", auth(handler.declineBoardInvitationHandler)).Methods("DELETE")
 
 	// Task routes
-	api.HandleFunc("/tasks", middleware.AuthMiddleware(conf, handler.createTaskHandler)).Methods("POST")
-	api.HandleFunc("/tasks/{id}", middleware.AuthMiddleware(conf, handler.getTaskHandler)).Methods("GET")
-	api.HandleFunc("/tasks/{id}", middleware.AuthMiddleware(conf, handler.updateTaskHandler)).Methods("PATCH")
-	api.HandleFunc("/tasks/{id}", middleware.AuthMiddleware(conf, handler.deleteTaskHandler)).Methods("DELETE")
-	api.HandleFunc("/tasks/{id}/comments", middleware.AuthMiddleware(conf, handler.addCommentHandler)).Methods("POST")
+	api.HandleFunc("/tasks", auth(handler.listTasksHandler, models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/tasks", auth(idempotent(handler.createTaskHandler), models.PermTaskCreate)).Methods("POST")
+	api.HandleFunc("/tasks/{id}", auth(handler.getTaskHandler, models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/tasks/{id}", auth(handler.updateTaskHandler, models.PermTaskUpdate, models.PermTaskMove)).Methods("PATCH")
+	api.HandleFunc("/tasks/{id}", auth(handler.deleteTaskHandler, models.PermTaskDelete)).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/comments", auth(idempotent(handler.addCommentHandler), models.PermTaskComment)).Methods("POST")
+
+	// Saved task filters, shared ones are visible to the organization
+	api.HandleFunc("/filters", auth(handler.getFiltersHandler, models.PermBoardView)).Methods("GET")
+	api.HandleFunc("/filters", auth(idempotent(handler.createFilterHandler), models.PermBoardView)).Methods("POST")
+	api.HandleFunc("/filters/{id}", auth(handler.updateFilterHandler, models.PermBoardView)).Methods("PUT")
+	api.HandleFunc("/filters/{id}", auth(handler.deleteFilterHandler, models.PermBoardView)).Methods("DELETE")
 
 	// User routes
-	api.HandleFunc("/users", middleware.AuthMiddleware(conf, handler.getUsersHandler)).Methods("GET")
-	api.HandleFunc("/users", middleware.AuthMiddleware(conf, handler.createUserHandler)).Methods("POST")
-	api.HandleFunc("/users/{id}", middleware.AuthMiddleware(conf, handler.deleteUserHandler)).Methods("DELETE")
-	api.HandleFunc("/users/{id}/role", middleware.AuthMiddleware(conf, handler.updateUserRoleHandler)).Methods("PATCH")
+	api.HandleFunc("/users", auth(handler.getUsersHandler, models.PermUserView)).Methods("GET")
+	api.HandleFunc("/users", auth(idempotent(handler.createUserHandler), models.PermUserManage)).Methods("POST")
+	api.HandleFunc("/users/{id}", auth(handler.deleteUserHandler, models.PermUserManage)).Methods("DELETE")
+	api.HandleFunc("/users/{id}/role", auth(handler.updateUserRoleHandler, models.PermUserManage)).Methods("PATCH")
+	api.HandleFunc("/users/{id}/deactivate", auth(handler.deactivateUserHandler, models.PermUserManage)).Methods("POST")
+	api.HandleFunc("/users/{id}/reactivate", auth(handler.reactivateUserHandler, models.PermUserManage)).Methods("POST")
+	api.HandleFunc("/users/{id}/export", slowAuth(handler.exportUserHandler, models.PermUserManage)).Methods("GET")
+	api.HandleFunc("/users/{id}/erase", auth(handler.eraseUserHandler, models.PermUserManage)).Methods("POST")
+	api.HandleFunc("/users/{id}/2fa", auth(handler.updateUserTwoFactorHandler, models.PermUserManage)).Methods("PATCH")
+	api.HandleFunc("/users/{id}/2fa", auth(handler.resetUserTwoFactorHandler, models.PermUserManage)).Methods("DELETE")
+	api.HandleFunc("/users/{id}/unlock", auth(handler.unlockUserHandler, models.PermUserManage)).Methods("POST")
+
+	// Invitation routes, the link creates an account in the organization
+	api.HandleFunc("/invitations", auth(handler.getInvitationsHandler, models.PermUserManage)).Methods("GET")
+	api.HandleFunc("/invitations", auth(handler.createInvitationHandler, models.PermUserManage)).Methods("POST")
+	api.HandleFunc("/invitations/{id}", auth(handler.revokeInvitationHandler, models.PermUserManage)).Methods("DELETE")
+
+	// Role routes
+	api.HandleFunc("/roles", auth(handler.getRolesHandler, models.PermUserView)).Methods("GET")
+	api.HandleFunc("/roles/{name}", auth(handler.saveRoleHandler, models.PermRoleManage)).Methods("PUT")
+	api.HandleFunc("/roles/{name}", auth(handler.deleteRoleHandler, models.PermRoleManage)).Methods("DELETE")
+	api.HandleFunc("/permissions", auth(handler.getPermissionsHandler, models.PermUserView)).Methods("GET")
+
+	// Group routes
+	api.HandleFunc("/groups", auth(handler.getGroupsHandler, models.PermUserView)).Methods("GET")
+	api.HandleFunc("/groups", auth(idempotent(handler.createGroupHandler), models.PermGroupManage)).Methods("POST")
+	api.HandleFunc("/groups/{id}", auth(handler.getGroupHandler, models.PermUserView)).Methods("GET")
+	api.HandleFunc("/groups/{id}", auth(handler.updateGroupHandler, models.PermGroupManage)).Methods("PATCH")
+	api.HandleFunc("/groups/{id}", auth(handler.deleteGroupHandler, models.PermGroupManage)).Methods("DELETE")
+	api.HandleFunc("/groups/{id}/members/{userId}", auth(handler.addGroupMemberHandler, models.PermGroupManage)).Methods("PUT")
+	api.HandleFunc("/groups/{id}/members/{userId}", auth(handler.removeGroupMemberHandler, models.PermGroupManage)).Methods("DELETE")
+
+	// Organization routes
+	api.HandleFunc("/org", auth(handler.getCurrentOrgHandler)).Methods("GET")
+	api.HandleFunc("/org", auth(handler.updateCurrentOrgHandler, models.PermOrgConfigure)).Methods("PATCH")
+	api.HandleFunc("/orgs", auth(handler.getOrgsHandler, models.PermOrgManage)).Methods("GET")
+	api.HandleFunc("/orgs", auth(handler.createOrgHandler, models.PermOrgManage)).Methods("POST")
+	api.HandleFunc("/orgs/{orgId:[0-9]+}", auth(handler.updateOrgHandler, models.PermOrgManage)).Methods("PATCH")
+	api.HandleFunc("/orgs/{orgId:[0-9]+}/users", auth(handler.createUserHandler, models.PermOrgManage)).Methods("POST")
 
 	// Notification routes
-	api.HandleFunc("/notifications", middleware.AuthMiddleware(conf, handler.getNotificationsHandler)).Methods("GET")
-	api.HandleFunc("/notifications/{id}/read", middleware.AuthMiddleware(conf, handler.markNotificationReadHandler)).Methods("PATCH")
+	api.HandleFunc("/notifications", auth(handler.getNotificationsHandler)).Methods("GET")
+	api.HandleFunc("/notifications/{id}/read", auth(handler.markNotificationReadHandler)).Methods("PATCH")
 }
 
 // Board handlers
 func (h *handlerDeps) getBoardHandler(w http.ResponseWriter, r *http.Request) {
-	board, err := h.board.GetBoard()
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	role, ok := h.boardAccess(w, r, boardID, models.PermBoardView)
+	if !ok {
+		return
+	}
+
+	// ?groupId= shows the tasks of a group and of its members, ?query= or a
+	// saved ?filter= the tasks matching a task query, ?comments=count or none
+	// leaves the comments of large boards out
+	query, ok := h.requestQuery(w, r)
+	if !ok {
+		return
+	}
+	filter := models.BoardFilter{
+		GroupID:  r.URL.Query().Get("groupId"),
+		Comments: r.URL.Query().Get("comments"),
+		Query:    query,
+		UserID:   caller(r).UserID,
+	}
+	switch filter.Comments {
+	case "", models.BoardCommentsAll, models.BoardCommentsCount, models.BoardCommentsNone:
+	default:
+		writeError(w, r, service.ValidationError(map[string]string{"comments": "must be all, count or none"}))
+		return
+	}
+	if filter.GroupID != "" {
+		exists, err := h.groups.Exists(r.Context(), orgID, filter.GroupID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !exists {
+			apierror.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	// The version covers the whole board, filtered views aren't cached since
+	// group membership and renames don't bump it
+	filtered := filter.GroupID != "" || filter.Query != ""
+	if !filtered && r.Header.Get("If-None-Match") != "" {
+		version, err := h.board.BoardVersion(r.Context(), orgID, boardID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if notModified(w, r, version) {
+			return
+		}
+	}
+
+	board, err := h.board.GetBoard(r.Context(), orgID, boardID, filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	board.Role = role
+	if !filtered {
+		setETag(w, board.Version)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(*board)
 }
 
 func (h *handlerDeps) createTaskHandler(w http.ResponseWriter, r *http.Request) {
-	// Only admins can create tasks
-	role := r.Context().Value("role").(string)
-	if role != "admin" {
-		http.Error(w, "Unauthorized", http.StatusForbidden)
+	userID := caller(r).UserID
+	orgID := caller(r).OrgID
+
+	var req models.CreateTaskRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := service.ValidateCreateTask(&req); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	userID := r.Context().Value("userId").(string)
-
-	var task models.Task
-	err := json.NewDecoder(r.Body).Decode(&task)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	task := models.Task{
+		BoardID:     req.BoardID,
+		Title:       strings.TrimSpace(req.Title),
+		Description: req.Description,
+		State:       req.State,
+		Priority:    models.PriorityLow,
+		AssigneeIDs: req.AssigneeIDs,
+		GroupID:     req.GroupID,
+		Labels:      req.Labels,
+	}
+	if req.Priority != nil {
+		task.Priority = *req.Priority
+	}
+	var err error
+	if task.BoardID == 0 {
+		if task.BoardID, err = h.board.DefaultBoard(r.Context(), orgID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if _, ok := h.boardAccess(w, r, task.BoardID, models.PermTaskCreate); !ok {
+		return
+	}
+	// The single assignee of older clients joins the assignee list
+	if req.Assignee != "" && req.Assignee != "null" {
+		task.AssigneeIDs = append(task.AssigneeIDs, req.Assignee)
+	}
+	for _, assignee := range task.AssigneeIDs {
+		if !h.checkAssignee(w, r, task.BoardID, assignee) {
+			return
+		}
+	}
+	if !h.checkGroup(w, r, task.GroupID) {
 		return
 	}
 
-	err = h.task.CreateTask(userID, &task) //Проверить указатель на таску
+	err = h.task.CreateTask(r.Context(), orgID, userID, &task) //Проверить указатель на таску
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
+	setETag(w, task.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(task)
 }
 
 // Task handlers
+
+// listTasksHandler lists the tasks of the boards the user can see a page at a
+// time, see taskListFilter for the parameters. ?query= or a saved ?filter=
+// narrows them down with a task query
+func (h *handlerDeps) listTasksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+	orgID := caller(r).OrgID
+
+	query, ok := h.requestQuery(w, r)
+	if !ok {
+		return
+	}
+	filter, err := taskListFilter(r)
+	if err == nil {
+		filter.Query = query
+		err = service.ValidateTaskListFilter(&filter)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if filter.BoardID != 0 {
+		if _, ok := h.boardAccess(w, r, filter.BoardID, models.PermBoardView); !ok {
+			return
+		}
+	}
+
+	page, err := h.task.ListTasks(r.Context(), orgID, userID, middleware.HasPermission(r, models.PermBoardManage), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// taskListFilter reads the task list parameters: boardId, state and label
+// (repeated or comma-separated, a task needs every label), assignee and
+// creator (a user id or "me"), priorityMin and
+// priorityMax, createdFrom, createdTo, updatedFrom and updatedTo (RFC 3339 or
+// a date, "to" dates include the whole day), q, sort, order, limit and cursor
+func taskListFilter(r *http.Request) (models.TaskListFilter, error) {
+	query := r.URL.Query()
+	fields := make(map[string]string)
+	number := func(name string) int {
+		value := query.Get(name)
+		if value == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fields[name] = "must be an integer"
+		}
+		return n
+	}
+	user := func(name string) string {
+		if value := query.Get(name); value != "me" {
+			return value
+		}
+		return caller(r).UserID
+	}
+	date := func(name string, end bool) time.Time {
+		value := query.Get(name)
+		if value == "" {
+			return time.Time{}
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+		t, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			fields[name] = "must be an RFC 3339 time or a date"
+		}
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t
+	}
+
+	filter := models.TaskListFilter{
+		BoardID:     number("boardId"),
+		AssigneeID:  user("assignee"),
+		CreatorID:   user("creator"),
+		PriorityMin: number("priorityMin"),
+		PriorityMax: number("priorityMax"),
+		CreatedFrom: date("createdFrom", false),
+		CreatedTo:   date("createdTo", true),
+		UpdatedFrom: date("updatedFrom", false),
+		UpdatedTo:   date("updatedTo", true),
+		Text:        query.Get("q"),
+		Sort:        query.Get("sort"),
+		Order:       query.Get("order"),
+		Limit:       number("limit"),
+		Cursor:      query.Get("cursor"),
+	}
+	for _, value := range query["state"] {
+		for _, state := range strings.Split(value, ",") {
+			if state = strings.TrimSpace(state); state != "" {
+				filter.States = append(filter.States, state)
+			}
+		}
+	}
+	for _, value := range query["label"] {
+		for _, label := range strings.Split(value, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				filter.Labels = append(filter.Labels, label)
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return filter, service.ValidationError(fields)
+	}
+	return filter, nil
+}
+
 func (h *handlerDeps) getTaskHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	taskID := vars["id"]
 
+	if _, _, ok := h.taskAccess(w, r, taskID, models.PermBoardView); !ok {
+		return
+	}
+
 	var task models.Task
-	err := h.task.GetTask(taskID, &task)
+	err := h.task.GetTask(r.Context(), caller(r).OrgID, taskID, &task)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	if notModified(w, r, task.Version) {
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -111,46 +426,68 @@ func (h *handlerDeps) updateTaskHandler(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	taskID := vars["id"]
 
-	var updates map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&updates)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	ifVersion, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	var req models.UpdateTaskRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := service.ValidateUpdateTask(&req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	boardID, role, ok := h.taskAccess(w, r, taskID, models.PermBoardView)
+	if !ok {
 		return
 	}
 
-	// Check if user is admin or just updating state
-	role := r.Context().Value("role").(string)
-	if role != "admin" {
-		// Regular users can only update state
-		if _, stateExists := updates["state"]; !stateExists || len(updates) > 1 {
-			http.Error(w, "Unauthorized: Regular users can only update task state", http.StatusForbidden)
+	// Users without task.update can only move the task to another column
+	if !boardAllows(r, role, models.PermTaskUpdate) {
+		if !req.StateOnly() || !boardAllows(r, role, models.PermTaskMove) {
+			apierror.Error(w, "Permission denied: only the task state can be updated", http.StatusForbidden)
+			return
+		}
+	}
+	assignees, _ := req.AssigneeUpdate()
+	for _, assignee := range assignees {
+		if !h.checkAssignee(w, r, boardID, assignee) {
 			return
 		}
 	}
+	if groupID, ok := req.GroupUpdate(); ok && !h.checkGroup(w, r, groupID) {
+		return
+	}
 
-	task, err := h.task.UpdateTask(taskID, updates)
+	task, err := h.task.UpdateTask(r.Context(), caller(r).OrgID, taskID, req, ifVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
+	setETag(w, task.Version)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(*task)
 }
 
 func (h *handlerDeps) deleteTaskHandler(w http.ResponseWriter, r *http.Request) {
-	// Only admins can delete tasks
-	role := r.Context().Value("role").(string)
-	if role != "admin" {
-		http.Error(w, "Unauthorized", http.StatusForbidden)
-		return
-	}
-
 	vars := mux.Vars(r)
 	taskID := vars["id"]
 
-	err := h.task.DeleteTask(taskID)
+	if _, _, ok := h.taskAccess(w, r, taskID, models.PermTaskDelete); !ok {
+		return
+	}
+	ifVersion, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.task.DeleteTask(r.Context(), caller(r).OrgID, taskID, ifVersion)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -166,17 +503,24 @@ type addCommentRequest struct {
 func (h *handlerDeps) addCommentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	taskID := vars["id"]
-	userID := r.Context().Value("userId").(string)
+	userID := caller(r).UserID
 
 	var req addCommentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Content == "" {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, r, service.ValidationError(map[string]string{"content": "is required"}))
 		return
 	}
 
-	comment, err := h.task.AddComment(userID, taskID, req.Content)
+	if _, _, ok := h.taskAccess(w, r, taskID, models.PermTaskComment); !ok {
+		return
+	}
+
+	comment, err := h.task.AddComment(r.Context(), caller(r).OrgID, userID, taskID, req.Content)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -186,9 +530,10 @@ func (h *handlerDeps) addCommentHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *handlerDeps) getUsersHandler(w http.ResponseWriter, r *http.Request) {
-	users, err := h.user.GetUsers()
+	users, err := h.user.GetUsers(r.Context(), caller(r).OrgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -202,93 +547,227 @@ type createUserRequest struct {
 	Role     string `json:"role"`
 }
 
-func (h *handlerDeps) createUserHandler(w http.ResponseWriter, r *http.Request) {
-	role := r.Context().Value("role").(string)
-	if role != "admin" {
-		http.Error(w, "Unauthorized", http.StatusForbidden)
-		return
+// assignableRole checks that the role exists and doesn't grant more than the
+// current user has, so admins can't hand out permissions they lack
+func (h *handlerDeps) assignableRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	exists, err := h.roles.Exists(r.Context(), role)
+	if err != nil {
+		writeError(w, r, err)
+		return false
 	}
+	if !exists {
+		apierror.Error(w, "Invalid role", http.StatusBadRequest)
+		return false
+	}
+	perms, err := h.roles.Permissions(r.Context(), role)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	for p := range perms {
+		if !middleware.HasPermission(r, p) {
+			apierror.Error(w, "Permission denied: the role grants permissions you don't have", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// manageableUser checks that the caller may manage the target user: a
+// super-admin only by another super-admin, and a user of a role only by a
+// caller holding all the permissions of that role
+func (h *handlerDeps) manageableUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	target, err := h.user.GetUser(r.Context(), caller(r).OrgID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if target.SuperAdmin && !caller(r).SuperAdmin {
+		apierror.Error(w, "Permission denied: the user is a super-admin", http.StatusForbidden)
+		return false
+	}
+	perms, err := h.roles.Permissions(r.Context(), target.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	for p := range perms {
+		if !middleware.HasPermission(r, p) {
+			apierror.Error(w, "Permission denied: the user's role has permissions you don't have", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// caller returns the authenticated identity of the request
+func caller(r *http.Request) *middleware.Identity {
+	return middleware.IdentityFrom(r.Context())
+}
+
+// targetOrgID returns the organization of the route, super-admin routes name
+// it in the path and the others use the organization of the current user
+func targetOrgID(r *http.Request) int {
+	if id, err := strconv.Atoi(mux.Vars(r)["orgId"]); err == nil {
+		return id
+	}
+	return caller(r).OrgID
+}
+
+func (h *handlerDeps) createUserHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := targetOrgID(r)
 
 	var req createUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Email == "" || req.Password == "" {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := service.ValidateNewUser(req.Username, req.Email, req.Password); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	if req.Role == "" {
-		req.Role = "user"
+		role, err := h.orgs.DefaultRole(r.Context(), orgID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		req.Role = role
+	}
+	if !h.assignableRole(w, r, req.Role) {
+		return
 	}
 
-	user, err := h.user.CreateUser(req.Username, req.Email, req.Password, req.Role)
+	user, err := h.user.CreateUser(r.Context(), orgID, strings.TrimSpace(req.Username), req.Email, req.Password, req.Role)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	if err = h.members.JoinDefaultBoard(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("Error adding user to the default board", "err", err)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
 }
 
+// deleteUserHandler deletes a user, ?reassignTo= hands its tasks to another
+// user and ?keepState=true leaves them in their column
 func (h *handlerDeps) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	role := r.Context().Value("role").(string)
-	if role != "admin" {
-		http.Error(w, "Unauthorized", http.StatusForbidden)
-		return
-	}
 	vars := mux.Vars(r)
 	userID := vars["id"]
 	if userID == "" {
-		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		apierror.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	if userID == caller(r).UserID {
+		apierror.Error(w, "You can't delete your own account", http.StatusBadRequest)
 		return
 	}
-	if err := h.user.DeleteUser(userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	opts := models.DeleteUserOptions{
+		ReassignTo: r.URL.Query().Get("reassignTo"),
+		KeepState:  r.URL.Query().Get("keepState") == "true",
+	}
+	if !h.manageableUser(w, r, userID) {
+		return
+	}
+	err := h.user.DeleteUser(r.Context(), caller(r).OrgID, userID, opts)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted"})
 }
 
+func (h *handlerDeps) deactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	h.setUserActive(w, r, false)
+}
+
+func (h *handlerDeps) reactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	h.setUserActive(w, r, true)
+}
+
+func (h *handlerDeps) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	userID := mux.Vars(r)["id"]
+	if userID == caller(r).UserID {
+		apierror.Error(w, "You can't deactivate your own account", http.StatusBadRequest)
+		return
+	}
+	if !h.manageableUser(w, r, userID) {
+		return
+	}
+
+	err := h.user.SetActive(r.Context(), caller(r).OrgID, userID, active)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	message := "User deactivated"
+	if active {
+		message = "User reactivated"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
 type updateUserRoleRequest struct {
 	Role string `json:"role"`
 }
 
 func (h *handlerDeps) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
-	role := r.Context().Value("role").(string)
-	if role != "admin" {
-		http.Error(w, "Unauthorized", http.StatusForbidden)
-		return
-	}
 	vars := mux.Vars(r)
 	userID := vars["id"]
 	if userID == "" {
-		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		apierror.Error(w, "Invalid user id", http.StatusBadRequest)
 		return
 	}
 	var req updateUserRoleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Role == "" {
+		writeError(w, r, service.ValidationError(map[string]string{"role": "is required"}))
 		return
 	}
-	if req.Role != "admin" && req.Role != "user" {
-		http.Error(w, "Invalid role", http.StatusBadRequest)
+	if !h.manageableUser(w, r, userID) || !h.assignableRole(w, r, req.Role) {
 		return
 	}
-	if _, err := h.db.Exec("UPDATE users SET role = $1 WHERE id = $2", req.Role, userID); err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	err := h.user.UpdateRole(r.Context(), caller(r).OrgID, userID, req.Role)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "User role updated"})
 }
 
+func (h *handlerDeps) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	userID := mux.Vars(r)["id"]
+	if !h.manageableUser(w, r, userID) {
+		return
+	}
+
+	err := h.lockout.Unlock(r.Context(), orgID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User unlocked"})
+}
+
 // Notification handlers
 func (h *handlerDeps) getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("userId").(string)
+	userID := caller(r).UserID
 
-	notifications, err := h.notification.GetNotification(userID)
+	notifications, err := h.notification.GetNotification(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -298,11 +777,12 @@ func (h *handlerDeps) getNotificationsHandler(w http.ResponseWriter, r *http.Req
 func (h *handlerDeps) markNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	notificationID := vars["id"]
-	userID := r.Context().Value("userId").(string)
+	userID := caller(r).UserID
 
-	err := h.notification.MarkNotificationRead(userID, notificationID)
+	err := h.notification.MarkNotificationRead(r.Context(), userID, notificationID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -316,19 +796,30 @@ func (h *handlerDeps) updateBoardColumnsHandler(w http.ResponseWriter, r *http.R
 		Columns []service.ColumnUpdate `json:"columns"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &requestData) {
+		return
+	}
+	if err := service.ValidateColumns(requestData.Columns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.boardAccess(w, r, boardID, models.PermBoardConfigure); !ok {
 		return
 	}
 
-	if len(requestData.Columns) == 0 {
-		http.Error(w, "Columns array cannot be empty", http.StatusBadRequest)
+	ifVersion, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	err := h.board.UpdateBoardColumns(requestData.Columns)
+	err := h.board.UpdateBoardColumns(r.Context(), caller(r).OrgID, boardID, requestData.Columns, ifVersion)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update columns: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
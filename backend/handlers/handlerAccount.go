@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/models"
+	"belykh-ik/taskflow/service"
+)
+
+// Password reset and email verification handlers
+
+func (h *AuthDbDeps) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.account.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Same answer whether or not the email is registered
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If the email is registered, a reset link has been sent",
+	})
+}
+
+func (h *AuthDbDeps) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.NewPassword == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.account.ResetPassword(r.Context(), req.Token, req.NewPassword)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Пароль изменен"})
+}
+
+func (h *AuthDbDeps) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.account.VerifyEmail(r.Context(), req.Token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+func (h *AuthDbDeps) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	user, err := h.loadUser(r.Context(), userID)
+	if err != nil {
+		writeLookupError(w, r, err, service.ErrUserNotFound)
+		return
+	}
+	if user.EmailVerified {
+		apierror.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	if err := h.account.SendEmailVerification(r.Context(), user.ID, user.Email); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
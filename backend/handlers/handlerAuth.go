@@ -1,134 +1,309 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/metrics"
 	"belykh-ik/taskflow/middleware"
 	"belykh-ik/taskflow/models"
+	"belykh-ik/taskflow/service"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 )
 
 type AuthDbDeps struct {
-	db   *sql.DB
-	conf *models.Config
+	db          *sql.DB
+	conf        *models.Config
+	tokens      *service.TokenDeps
+	oidc        *service.OIDCDeps
+	twoFactor   *service.TwoFactorDeps
+	account     *service.AccountDeps
+	lockout     *service.LockoutDeps
+	members     *service.MemberDeps
+	orgs        *service.OrgDeps
+	invitations *service.InvitationDeps
+	privacy     *service.PrivacyDeps
 }
 
-func RegisterAuthRoures(r *mux.Router, db *sql.DB, conf *models.Config) {
+func RegisterAuthRoures(r *mux.Router, db *sql.DB, conf *models.Config, authz *middleware.Auth, tokens *service.TokenDeps, oidc *service.OIDCDeps, twoFactor *service.TwoFactorDeps, account *service.AccountDeps, lockout *service.LockoutDeps, members *service.MemberDeps, orgs *service.OrgDeps, invitations *service.InvitationDeps, privacy *service.PrivacyDeps) {
 	handler := &AuthDbDeps{
-		db:   db,
-		conf: conf,
+		db:          db,
+		conf:        conf,
+		tokens:      tokens,
+		oidc:        oidc,
+		twoFactor:   twoFactor,
+		account:     account,
+		lockout:     lockout,
+		members:     members,
+		orgs:        orgs,
+		invitations: invitations,
+		privacy:     privacy,
 	}
+	// Requests get a deadline, exports run longer than the rest
+	timeout := middleware.Timeout(conf.Server.RequestTimeout)
+	slowTimeout := middleware.Timeout(conf.Server.SlowRequestTimeout)
+	auth := func(next http.HandlerFunc) http.HandlerFunc {
+		return timeout(authz.Require(next))
+	}
+	slowAuth := func(next http.HandlerFunc) http.HandlerFunc {
+		return slowTimeout(authz.Require(next))
+	}
+	// Credential endpoints share a stricter limit
+	limit := middleware.NewRateLimiter(conf.RateLimits.Auth, conf.ProxyHops).Limit
+
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
 
 	// Auth routes
-	api.HandleFunc("/auth/register", handler.registerHandler).Methods("POST")
-	api.HandleFunc("/auth/login", handler.loginHandler).Methods("POST")
-	api.HandleFunc("/auth/me", middleware.AuthMiddleware(conf, handler.getCurrentUserHandler)).Methods("GET")
-	api.HandleFunc("/auth/me", middleware.AuthMiddleware(conf, handler.updateCurrentUserHandler)).Methods("PATCH")
-	api.HandleFunc("/auth/change-password", middleware.AuthMiddleware(conf, handler.changePasswordHandler)).Methods("POST")
+	api.HandleFunc("/auth/providers", timeout(handler.getProvidersHandler)).Methods("GET")
+	api.HandleFunc("/auth/register", timeout(limit(handler.registerHandler))).Methods("POST")
+	api.HandleFunc("/auth/login", timeout(limit(handler.loginHandler))).Methods("POST")
+	api.HandleFunc("/auth/bootstrap", timeout(limit(handler.bootstrapHandler))).Methods("POST")
+	api.HandleFunc("/auth/invitation", timeout(limit(handler.getInvitationHandler))).Methods("GET")
+	api.HandleFunc("/auth/invitation/accept", timeout(limit(handler.acceptInvitationHandler))).Methods("POST")
+	api.HandleFunc("/auth/login/2fa", timeout(limit(handler.loginTwoFactorHandler))).Methods("POST")
+	api.HandleFunc("/auth/login/2fa/enroll", timeout(limit(handler.loginEnrollTwoFactorHandler))).Methods("POST")
+	api.HandleFunc("/auth/me", auth(handler.getCurrentUserHandler)).Methods("GET")
+	api.HandleFunc("/auth/me", auth(handler.updateCurrentUserHandler)).Methods("PATCH")
+	api.HandleFunc("/auth/change-password", auth(limit(handler.changePasswordHandler))).Methods("POST")
+	api.HandleFunc("/auth/me/export", slowAuth(handler.exportCurrentUserHandler)).Methods("GET")
+	api.HandleFunc("/auth/me/erase", auth(limit(handler.eraseCurrentUserHandler))).Methods("POST")
+
+	// Password reset and email verification routes
+	api.HandleFunc("/auth/password/forgot", timeout(limit(handler.forgotPasswordHandler))).Methods("POST")
+	api.HandleFunc("/auth/password/reset", timeout(limit(handler.resetPasswordHandler))).Methods("POST")
+	api.HandleFunc("/auth/email/verify", timeout(limit(handler.verifyEmailHandler))).Methods("POST")
+	api.HandleFunc("/auth/email/resend", auth(limit(handler.resendVerificationHandler))).Methods("POST")
+
+	// Personal access token routes
+	api.HandleFunc("/auth/tokens", auth(handler.getTokensHandler)).Methods("GET")
+	api.HandleFunc("/auth/tokens", auth(handler.createTokenHandler)).Methods("POST")
+	api.HandleFunc("/auth/tokens/{id}", auth(handler.revokeTokenHandler)).Methods("DELETE")
+
+	// Two-factor authentication routes
+	api.HandleFunc("/auth/2fa", auth(handler.getTwoFactorHandler)).Methods("GET")
+	api.HandleFunc("/auth/2fa/setup", auth(handler.setupTwoFactorHandler)).Methods("POST")
+	api.HandleFunc("/auth/2fa/enable", auth(handler.enableTwoFactorHandler)).Methods("POST")
+	api.HandleFunc("/auth/2fa/disable", auth(handler.disableTwoFactorHandler)).Methods("POST")
+	api.HandleFunc("/auth/2fa/recovery-codes", auth(handler.regenerateRecoveryCodesHandler)).Methods("POST")
+
+	// Single sign-on routes
+	api.HandleFunc("/auth/oidc/login", timeout(handler.oidcLoginHandler)).Methods("GET")
+	api.HandleFunc("/auth/oidc/callback", timeout(handler.oidcCallbackHandler)).Methods("GET")
 
 }
 
 // Authentication handlers
+func (h *AuthDbDeps) getProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"password":     !h.conf.PasswordLoginDisabled,
+		"registration": !h.conf.PasswordLoginDisabled && h.conf.OpenRegistration,
+		"oidc":         h.conf.OIDC.Enabled(),
+	})
+}
+
 func (h *AuthDbDeps) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if h.conf.PasswordLoginDisabled {
+		apierror.Error(w, "Password registration is disabled, use single sign-on", http.StatusForbidden)
+		return
+	}
+	if !h.conf.OpenRegistration {
+		apierror.Error(w, "Registration is by invitation only", http.StatusForbidden)
+		return
+	}
+
 	var req models.RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badJSON(w, err)
 		return
 	}
 
 	// Check if email already exists
 	var count int
-	err := h.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", req.Email).Scan(&count)
+	err := h.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM users WHERE email = $1", req.Email).Scan(&count)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	if count > 0 {
-		http.Error(w, "Email already in use", http.StatusBadRequest)
+		apierror.Error(w, "Email already in use", http.StatusBadRequest)
 		return
 	}
 
 	// Store password directly without hashing
 	password := req.Password
 
-	// Determine role (first user is admin, rest are users)
-	role := "user"
-	err = h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	// New users get the default role of the default organization, the first
+	// super-admin is created through the bootstrap instead
+	role, err := h.orgs.DefaultRole(r.Context(), models.DefaultOrgID)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	if count == 0 {
-		role = "admin"
-	}
-
 	// Insert user
 	var userID string
-	err = h.db.QueryRow(
-		"INSERT INTO users (username, email, password, role, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		req.Username, req.Email, password, role, time.Now(),
+	err = h.db.QueryRowContext(r.Context(),
+		"INSERT INTO users (username, email, password, role, org_id, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		req.Username, req.Email, password, role, models.DefaultOrgID, time.Now(),
 	).Scan(&userID)
 
 	if err != nil {
-		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	if err = h.members.JoinDefaultBoard(r.Context(), userID); err != nil {
+		logging.FromContext(r.Context()).Error("Error adding user to the default board", "err", err)
+	}
+
+	if err = h.account.SendEmailVerification(r.Context(), userID, req.Email); err != nil {
+		logging.FromContext(r.Context()).Error("Error creating email verification", "err", err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "User registered successfully",
 	})
 }
 
+func (h *AuthDbDeps) loadUser(ctx context.Context, userID string) (*models.User, error) {
+	var user models.User
+	err := h.db.QueryRowContext(ctx,
+		"SELECT id, username, email, role, org_id, is_super_admin, email_verified, deactivated_at IS NULL, created_at FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.EmailVerified, &user.Active, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkLockout answers 429 with Retry-After while the account is locked
+func (h *AuthDbDeps) checkLockout(w http.ResponseWriter, r *http.Request, userID string) bool {
+	lockedUntil, err := h.lockout.LockedUntil(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if !lockedUntil.IsZero() {
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(time.Until(lockedUntil).Seconds()))))
+		apierror.Error(w, "Account temporarily locked after too many failed attempts", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func (h *AuthDbDeps) recordFailure(r *http.Request, userID string) {
+	lockedUntil, err := h.lockout.RecordFailure(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error recording failed login", "err", err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		logging.FromContext(r.Context()).Warn("User locked after failed logins", "locked_user_id", userID, "locked_until", lockedUntil.Format(time.RFC3339))
+	}
+}
+
+// issueToken signs a session JWT for the user
+func (h *AuthDbDeps) issueToken(user models.User) (string, error) {
+	expirationTime := time.Now().Add(h.conf.JWT.Lifetime)
+	claims := &models.Claims{
+		UserID:     user.ID,
+		Role:       user.Role,
+		OrgID:      user.OrgID,
+		SuperAdmin: user.SuperAdmin,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(h.conf.JWT.Secret)
+}
+
 func (h *AuthDbDeps) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.conf.PasswordLoginDisabled {
+		apierror.Error(w, "Password login is disabled, use single sign-on", http.StatusForbidden)
+		return
+	}
+
 	var req models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badJSON(w, err)
 		return
 	}
 
 	// Get user by email
 	var user models.User
 	var password string
-	err := h.db.QueryRow(
-		"SELECT id, username, email, password, role, created_at FROM users WHERE email = $1",
-		req.Email,
-	).Scan(&user.ID, &user.Username, &user.Email, &password, &user.Role, &user.CreatedAt)
+	var totpEnabled, totpRequired bool
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT u.id, u.username, u.email, u.password, u.role, u.org_id, u.is_super_admin, u.email_verified, u.deactivated_at IS NULL, u.totp_enabled,
+		       u.totp_required OR COALESCE((o.settings->>'requireTwoFactor')::boolean, false), u.created_at
+		FROM users u JOIN organizations o ON o.id = u.org_id
+		WHERE u.email = $1
+	`, req.Email,
+	).Scan(&user.ID, &user.Username, &user.Email, &password, &user.Role, &user.OrgID, &user.SuperAdmin, &user.EmailVerified, &user.Active, &totpEnabled, &totpRequired, &user.CreatedAt)
 
 	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		metrics.LoginFailures.WithLabelValues(metrics.LoginUnknownUser).Inc()
+		apierror.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	// Locked accounts don't get their password checked at all
+	if !h.checkLockout(w, r, user.ID) {
+		metrics.LoginFailures.WithLabelValues(metrics.LoginLocked).Inc()
 		return
 	}
 
 	// Check password directly without hashing
-	if password != req.Password {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+	if req.Password == "" || password != req.Password {
+		h.recordFailure(r, user.ID)
+		metrics.LoginFailures.WithLabelValues(metrics.LoginBadPassword).Inc()
+		apierror.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate JWT token
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &models.Claims{
-		UserID: user.ID,
-		Role:   user.Role,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	// Deactivated accounts are only reported after a correct password
+	if !user.Active {
+		metrics.LoginFailures.WithLabelValues(metrics.LoginDeactivated).Inc()
+		apierror.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(h.conf.JWT_SECRET)
+	if h.conf.RequireVerifiedEmail && !user.EmailVerified {
+		apierror.Error(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	// The session token is only issued after the second factor
+	if totpEnabled || totpRequired {
+		h.writeTwoFactorChallenge(w, r, user.ID, !totpEnabled)
+		return
+	}
+
+	if err = h.lockout.RecordSuccess(r.Context(), user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("Error resetting failed logins", "err", err)
+	}
+
+	// Generate JWT token
+	tokenString, err := h.issueToken(user)
 	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -142,16 +317,11 @@ func (h *AuthDbDeps) loginHandler(w http.ResponseWriter, r *http.Request) {
 
 func (h *AuthDbDeps) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
 	// User is already authenticated by middleware
-	userID := r.Context().Value("userId").(string)
-
-	var user models.User
-	err := h.db.QueryRow(
-		"SELECT id, username, email, role, created_at FROM users WHERE id = $1",
-		userID,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt)
+	userID := caller(r).UserID
 
+	user, err := h.loadUser(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeLookupError(w, r, err, service.ErrUserNotFound)
 		return
 	}
 
@@ -160,30 +330,35 @@ func (h *AuthDbDeps) getCurrentUserHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (h *AuthDbDeps) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("userId").(string)
-	var updates map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	userID := caller(r).UserID
+	var req models.UpdateProfileRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	if username, ok := updates["username"]; ok {
-		if _, err := h.db.Exec("UPDATE users SET username = $1 WHERE id = $2", username, userID); err != nil {
-			http.Error(w, "Database error", http.StatusInternalServerError)
-			return
-		}
+	if err := service.ValidateProfile(&req); err != nil {
+		writeError(w, r, err)
+		return
 	}
-	if email, ok := updates["email"]; ok {
-		if _, err := h.db.Exec("UPDATE users SET email = $1 WHERE id = $2", email, userID); err != nil {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+	if req.Username != nil {
+		if _, err := h.db.ExecContext(r.Context(), "UPDATE users SET username = $1 WHERE id = $2", strings.TrimSpace(*req.Username), userID); err != nil {
+			writeError(w, r, err)
 			return
 		}
 	}
-	var user models.User
-	if err := h.db.QueryRow("SELECT id, username, email, role, created_at FROM users WHERE id = $1", userID).
-		Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt); err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	user, err := h.loadUser(r.Context(), userID)
+	if err != nil {
+		writeLookupError(w, r, err, service.ErrUserNotFound)
 		return
 	}
+	// A new email only replaces the current one after it is verified
+	if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+		err := h.account.RequestEmailChange(r.Context(), userID, *req.Email)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		user.PendingEmail = *req.Email
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -194,23 +369,30 @@ type changePasswordRequest struct {
 }
 
 func (h *AuthDbDeps) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("userId").(string)
+	userID := caller(r).UserID
 	var req changePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 	var current string
-	if err := h.db.QueryRow("SELECT password FROM users WHERE id = $1", userID).Scan(&current); err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	if err := h.db.QueryRowContext(r.Context(), "SELECT password FROM users WHERE id = $1", userID).Scan(&current); err != nil {
+		writeLookupError(w, r, err, service.ErrUserNotFound)
+		return
+	}
+	if !h.checkLockout(w, r, userID) {
 		return
 	}
 	if current != req.CurrentPassword {
-		http.Error(w, "Текущий пароль неверен", http.StatusUnauthorized)
+		h.recordFailure(r, userID)
+		apierror.Error(w, "Текущий пароль неверен", http.StatusUnauthorized)
 		return
 	}
-	if _, err := h.db.Exec("UPDATE users SET password = $1 WHERE id = $2", req.NewPassword, userID); err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if err := h.lockout.RecordSuccess(r.Context(), userID); err != nil {
+		logging.FromContext(r.Context()).Error("Error resetting failed logins", "err", err)
+	}
+	if _, err := h.db.ExecContext(r.Context(), "UPDATE users SET password = $1 WHERE id = $2", req.NewPassword, userID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
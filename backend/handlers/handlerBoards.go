@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/middleware"
+	"belykh-ik/taskflow/models"
+	"belykh-ik/taskflow/service"
+
+	"github.com/gorilla/mux"
+)
+
+// Board and membership handlers
+
+// requestBoardID returns the board of the route, routes without a board ID
+// use the default board of the organization
+func (h *handlerDeps) requestBoardID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, ok := mux.Vars(r)["boardId"]
+	if !ok {
+		boardID, err := h.board.DefaultBoard(r.Context(), caller(r).OrgID)
+		if err != nil {
+			writeError(w, r, err)
+			return 0, false
+		}
+		return boardID, true
+	}
+	boardID, err := strconv.Atoi(id)
+	if err != nil {
+		writeError(w, r, service.ErrBoardNotFound)
+		return 0, false
+	}
+	return boardID, true
+}
+
+// boardAllows reports whether a user with the board role may use the
+// permission on the board. The global board.manage permission allows anything
+func boardAllows(r *http.Request, role, permission string) bool {
+	if middleware.HasPermission(r, models.PermBoardManage) {
+		return true
+	}
+	if permission != models.PermBoardManage && !middleware.HasPermission(r, permission) {
+		return false
+	}
+	return models.BoardRoleAllows(role, permission)
+}
+
+// boardAccess checks the permission on the board and answers the request
+// when it is denied. Boards the user isn't a member of are reported as missing
+func (h *handlerDeps) boardAccess(w http.ResponseWriter, r *http.Request, boardID int, permission string) (string, bool) {
+	userID := caller(r).UserID
+	orgID := caller(r).OrgID
+
+	role, err := h.members.BoardRole(r.Context(), orgID, boardID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return "", false
+	}
+
+	if !boardAllows(r, role, permission) {
+		if role == "" && !middleware.HasPermission(r, models.PermBoardManage) {
+			writeError(w, r, service.ErrBoardNotFound)
+			return "", false
+		}
+		apierror.Error(w, "Permission denied", http.StatusForbidden)
+		return "", false
+	}
+	return role, true
+}
+
+// taskAccess checks the permission on the board of the task and returns the
+// board with the role of the user on it
+func (h *handlerDeps) taskAccess(w http.ResponseWriter, r *http.Request, taskID, permission string) (int, string, bool) {
+	boardID, err := h.task.TaskBoard(r.Context(), caller(r).OrgID, taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return 0, "", false
+	}
+	role, ok := h.boardAccess(w, r, boardID, permission)
+	if !ok {
+		return 0, "", false
+	}
+	return boardID, role, true
+}
+
+// checkAssignee makes sure tasks are only assigned to active board members
+func (h *handlerDeps) checkAssignee(w http.ResponseWriter, r *http.Request, boardID int, assignee string) bool {
+	if assignee == "" {
+		return true
+	}
+	role, err := h.members.BoardRole(r.Context(), caller(r).OrgID, boardID, assignee)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if role == "" {
+		apierror.Error(w, "Assignee must be a member of the board", http.StatusBadRequest)
+		return false
+	}
+	active, err := h.user.IsActive(r.Context(), assignee)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if !active {
+		apierror.Error(w, "Assignee is deactivated", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (h *handlerDeps) getBoardsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+	orgID := caller(r).OrgID
+
+	boards, err := h.board.GetBoards(r.Context(), orgID, userID, middleware.HasPermission(r, models.PermBoardManage))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(boards)
+}
+
+func (h *handlerDeps) createBoardHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	board, err := h.board.CreateBoard(r.Context(), caller(r).OrgID, userID, req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(board)
+}
+
+func (h *handlerDeps) getBoardMembersHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.boardAccess(w, r, boardID, models.PermBoardView); !ok {
+		return
+	}
+
+	members, err := h.members.GetMembers(r.Context(), orgID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *handlerDeps) updateBoardMemberHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.boardAccess(w, r, boardID, models.PermBoardManage); !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.members.SetMemberRole(r.Context(), orgID, boardID, mux.Vars(r)["userId"], req.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member role updated"})
+}
+
+func (h *handlerDeps) removeBoardMemberHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	memberID := mux.Vars(r)["userId"]
+
+	// Members can always leave a board
+	permission := models.PermBoardManage
+	if memberID == caller(r).UserID {
+		permission = models.PermBoardView
+	}
+	if _, ok := h.boardAccess(w, r, boardID, permission); !ok {
+		return
+	}
+
+	err := h.members.RemoveMember(r.Context(), orgID, boardID, memberID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+}
+
+func (h *handlerDeps) getBoardInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.boardAccess(w, r, boardID, models.PermBoardManage); !ok {
+		return
+	}
+
+	invitations, err := h.members.GetInvitations(r.Context(), orgID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func (h *handlerDeps) inviteBoardMemberHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.boardAccess(w, r, boardID, models.PermBoardManage); !ok {
+		return
+	}
+	userID := caller(r).UserID
+
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := h.members.Invite(r.Context(), orgID, boardID, userID, req.Email, req.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+func (h *handlerDeps) revokeBoardInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	boardID, ok := h.requestBoardID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.boardAccess(w, r, boardID, models.PermBoardManage); !ok {
+		return
+	}
+
+	err := h.members.RevokeInvitation(r.Context(), orgID, boardID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation revoked"})
+}
+
+func (h *handlerDeps) getMyBoardInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	invitations, err := h.members.GetUserInvitations(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func (h *handlerDeps) acceptBoardInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	board, err := h.members.AcceptInvitation(r.Context(), userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
+
+func (h *handlerDeps) declineBoardInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	err := h.members.DeclineInvitation(r.Context(), userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation declined"})
+}
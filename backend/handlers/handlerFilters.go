@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"belykh-ik/taskflow/models"
+	"belykh-ik/taskflow/service"
+
+	"github.com/gorilla/mux"
+)
+
+// Saved filter handlers
+
+// requestQuery returns the task query of ?query= or of the saved filter
+// ?filter=, empty when neither is set
+func (h *handlerDeps) requestQuery(w http.ResponseWriter, r *http.Request) (string, bool) {
+	query := r.URL.Query().Get("query")
+	filterID := r.URL.Query().Get("filter")
+	if filterID == "" {
+		return query, true
+	}
+	if query != "" {
+		writeError(w, r, service.ValidationError(map[string]string{"filter": "can't be combined with query"}))
+		return "", false
+	}
+	saved, err := h.filters.GetFilter(r.Context(), caller(r).OrgID, caller(r).UserID, filterID)
+	if err != nil {
+		writeError(w, r, err)
+		return "", false
+	}
+	return saved.Query, true
+}
+
+func (h *handlerDeps) getFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	filters, err := h.filters.GetFilters(r.Context(), caller(r).OrgID, caller(r).UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filters)
+}
+
+func (h *handlerDeps) createFilterHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SavedFilterRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := service.ValidateSavedFilter(&req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	saved, err := h.filters.CreateFilter(r.Context(), caller(r).OrgID, caller(r).UserID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *handlerDeps) updateFilterHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SavedFilterRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := service.ValidateSavedFilter(&req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	saved, err := h.filters.UpdateFilter(r.Context(), caller(r).OrgID, caller(r).UserID, mux.Vars(r)["id"], req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *handlerDeps) deleteFilterHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.filters.DeleteFilter(r.Context(), caller(r).OrgID, caller(r).UserID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Filter deleted"})
+}
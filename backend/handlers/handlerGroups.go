@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"belykh-ik/taskflow/apierror"
+
+	"github.com/gorilla/mux"
+)
+
+// Group handlers
+
+type groupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// checkGroup makes sure tasks are only assigned to groups of the organization
+func (h *handlerDeps) checkGroup(w http.ResponseWriter, r *http.Request, groupID string) bool {
+	if groupID == "" {
+		return true
+	}
+	exists, err := h.groups.Exists(r.Context(), caller(r).OrgID, groupID)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if !exists {
+		apierror.Error(w, "Group not found", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (h *handlerDeps) getGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.groups.GetGroups(r.Context(), caller(r).OrgID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+func (h *handlerDeps) getGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group, err := h.groups.GetGroup(r.Context(), caller(r).OrgID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+func (h *handlerDeps) createGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.groups.CreateGroup(r.Context(), caller(r).OrgID, req.Name, req.Description)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+func (h *handlerDeps) updateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.groups.UpdateGroup(r.Context(), caller(r).OrgID, mux.Vars(r)["id"], req.Name, req.Description)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+func (h *handlerDeps) deleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.groups.DeleteGroup(r.Context(), caller(r).OrgID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Group deleted"})
+}
+
+func (h *handlerDeps) addGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := h.groups.AddMember(r.Context(), caller(r).OrgID, vars["id"], vars["userId"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member added"})
+}
+
+func (h *handlerDeps) removeGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := h.groups.RemoveMember(r.Context(), caller(r).OrgID, vars["id"], vars["userId"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+}
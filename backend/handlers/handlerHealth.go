@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"belykh-ik/taskflow/database"
+	"belykh-ik/taskflow/logging"
+
+	"github.com/gorilla/mux"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// Health answers the liveness and readiness probes of the orchestrator
+type Health struct {
+	db       *sql.DB
+	draining atomic.Bool
+}
+
+// RegisterHealthRoutes adds /healthz and /readyz, they need no authentication
+func RegisterHealthRoutes(r *mux.Router, db *sql.DB) *Health {
+	health := &Health{db: db}
+	r.HandleFunc("/healthz", health.livenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", health.readinessHandler).Methods("GET")
+	return health
+}
+
+// Drain makes /readyz fail so no new traffic is routed here during shutdown
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// livenessHandler answers as long as the process serves requests
+func (h *Health) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessHandler checks that the database is reachable and has the schema
+// this version expects
+func (h *Health) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	checks := map[string]string{"database": "ok", "schema": "ok"}
+	status := http.StatusOK
+	if err := h.db.PingContext(ctx); err != nil {
+		logging.FromContext(r.Context()).Warn("Readiness check failed, database unreachable", "err", err)
+		checks["database"], checks["schema"] = "unreachable", "unknown"
+		status = http.StatusServiceUnavailable
+	} else if err := database.SchemaReady(ctx, h.db); err != nil {
+		logging.FromContext(r.Context()).Warn("Readiness check failed", "err", err)
+		checks["schema"] = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	checks["status"] = "ok"
+	if status != http.StatusOK {
+		checks["status"] = "unavailable"
+	}
+	writeHealth(w, status, checks)
+}
+
+func writeHealth(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
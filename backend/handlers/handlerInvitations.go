@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Invitation and bootstrap handlers
+
+func (h *handlerDeps) getInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := h.invitations.GetInvitations(r.Context(), caller(r).OrgID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func (h *handlerDeps) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	userID := caller(r).UserID
+
+	var req models.CreateInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		role, err := h.orgs.DefaultRole(r.Context(), orgID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		req.Role = role
+	}
+	if !h.assignableRole(w, r, req.Role) {
+		return
+	}
+
+	resp, err := h.invitations.CreateInvitation(r.Context(), orgID, userID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *handlerDeps) revokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.invitations.RevokeInvitation(r.Context(), caller(r).OrgID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation revoked"})
+}
+
+func (h *AuthDbDeps) getInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := h.invitations.GetInvitation(r.Context(), token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitation)
+}
+
+func (h *AuthDbDeps) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Username == "" || req.Password == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.invitations.AcceptInvitation(r.Context(), req.Token, req.Username, req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// bootstrapHandler creates the first super-admin with the setup token logged
+// at startup
+func (h *AuthDbDeps) bootstrapHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.BootstrapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Username == "" || req.Email == "" || req.Password == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if h.conf.BootstrapToken == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(h.conf.BootstrapToken)) != 1 {
+		apierror.Error(w, "Invalid setup token", http.StatusForbidden)
+		return
+	}
+
+	user, err := h.invitations.BootstrapAdmin(r.Context(), req.Username, req.Email, req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
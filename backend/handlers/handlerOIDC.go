@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/service"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Single sign-on handlers
+
+const oidcFlowCookie = "smartboard_oidc"
+
+// oidcFlowClaims carries the flow secrets in a signed short-lived cookie,
+// so the callback can be served by any backend instance
+type oidcFlowClaims struct {
+	service.OIDCFlow
+	jwt.StandardClaims
+}
+
+func (h *AuthDbDeps) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	authURL, flow, err := h.oidc.BeginLogin(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("OIDC login error", "err", err)
+		apierror.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	expiresAt := time.Now().Add(10 * time.Minute)
+	claims := &oidcFlowClaims{
+		OIDCFlow:       *flow,
+		StandardClaims: jwt.StandardClaims{Audience: oidcFlowCookie, ExpiresAt: expiresAt.Unix()},
+	}
+	value, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.conf.JWT.Secret)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (h *AuthDbDeps) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// The flow cookie is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		h.oidcRedirect(w, r, "error", e)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		h.oidcRedirect(w, r, "error", "login session expired")
+		return
+	}
+	claims := &oidcFlowClaims{}
+	token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return h.conf.JWT.Secret, nil
+	})
+	if err != nil || !token.Valid || claims.Audience != oidcFlowCookie || claims.State == "" || claims.State != r.URL.Query().Get("state") {
+		h.oidcRedirect(w, r, "error", "invalid login state")
+		return
+	}
+
+	user, err := h.oidc.CompleteLogin(r.Context(), r.URL.Query().Get("code"), &claims.OIDCFlow)
+	if errors.Is(err, service.ErrOIDCEmailConflict) || errors.Is(err, service.ErrUserDeactivated) {
+		h.oidcRedirect(w, r, "error", err.Error())
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("OIDC callback error", "err", err)
+		h.oidcRedirect(w, r, "error", "single sign-on failed")
+		return
+	}
+
+	// Accounts that need a second factor get the same challenge as password
+	// logins instead of a session, the frontend trades it at /auth/login/2fa
+	status, err := h.twoFactor.GetStatus(r.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error loading two-factor status", "err", err)
+		h.oidcRedirect(w, r, "error", "single sign-on failed")
+		return
+	}
+	if status.Enabled || status.Required {
+		challenge, err := h.twoFactorChallenge(user.ID)
+		if err != nil {
+			h.oidcRedirect(w, r, "error", "error generating token")
+			return
+		}
+		fragment := url.Values{}
+		fragment.Set("challengeToken", challenge)
+		fragment.Set("enrollmentRequired", strconv.FormatBool(!status.Enabled))
+		h.oidcRedirectFragment(w, r, fragment)
+		return
+	}
+
+	tokenString, err := h.issueToken(*user)
+	if err != nil {
+		h.oidcRedirect(w, r, "error", "error generating token")
+		return
+	}
+	h.oidcRedirect(w, r, "token", tokenString)
+}
+
+// oidcRedirect sends the browser back to the frontend, the result is put in
+// the URL fragment so it never reaches server logs
+func (h *AuthDbDeps) oidcRedirect(w http.ResponseWriter, r *http.Request, key, value string) {
+	fragment := url.Values{}
+	fragment.Set(key, value)
+	h.oidcRedirectFragment(w, r, fragment)
+}
+
+func (h *AuthDbDeps) oidcRedirectFragment(w http.ResponseWriter, r *http.Request, fragment url.Values) {
+	target := h.conf.OIDC.FrontendURL
+	if target == "" {
+		target = "/"
+	}
+	http.Redirect(w, r, target+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Organization handlers
+
+type orgRequest struct {
+	Name     string             `json:"name"`
+	Settings models.OrgSettings `json:"settings"`
+}
+
+func (h *handlerDeps) getCurrentOrgHandler(w http.ResponseWriter, r *http.Request) {
+	org, err := h.orgs.GetOrg(r.Context(), caller(r).OrgID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+func (h *handlerDeps) updateCurrentOrgHandler(w http.ResponseWriter, r *http.Request) {
+	h.updateOrg(w, r, caller(r).OrgID)
+}
+
+func (h *handlerDeps) updateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.Atoi(mux.Vars(r)["orgId"])
+	if err != nil {
+		apierror.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	h.updateOrg(w, r, orgID)
+}
+
+// updateOrg changes the fields present in the request. The default role is
+// handed out by registration, single sign-on and invitations, so it is
+// limited to roles the caller could assign
+func (h *handlerDeps) updateOrg(w http.ResponseWriter, r *http.Request, orgID int) {
+	var req models.OrgUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if role := req.Settings.DefaultRole; role != nil && *role != "" && !h.assignableRole(w, r, *role) {
+		return
+	}
+
+	org, err := h.orgs.UpdateOrg(r.Context(), orgID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+func (h *handlerDeps) getOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	orgs, err := h.orgs.GetOrgs(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+func (h *handlerDeps) createOrgHandler(w http.ResponseWriter, r *http.Request) {
+	var req orgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Settings.DefaultRole != "" && !h.assignableRole(w, r, req.Settings.DefaultRole) {
+		return
+	}
+
+	org, err := h.orgs.CreateOrg(r.Context(), req.Name, req.Settings)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
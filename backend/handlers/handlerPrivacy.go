@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/models"
+	"belykh-ik/taskflow/service"
+
+	"github.com/gorilla/mux"
+)
+
+// Personal data export and erasure handlers
+
+// writeExport sends the export as a JSON attachment, or as a ZIP archive with
+// one file per section when format=zip is asked
+func writeExport(w http.ResponseWriter, r *http.Request, export *models.UserExport) {
+	name := fmt.Sprintf("smartboard-export-%s", export.Profile.ID)
+	if r.URL.Query().Get("format") != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"comments.json", export.Comments},
+		{"assigned_tasks.json", export.AssignedTasks},
+		{"created_tasks.json", export.CreatedTasks},
+		{"notifications.json", export.Notifications},
+		{"boards.json", export.Boards},
+		{"groups.json", export.Groups},
+		{"api_tokens.json", export.APITokens},
+		{"saved_filters.json", export.SavedFilters},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error writing export archive", "err", err)
+			return
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			logging.FromContext(r.Context()).Error("Error writing export archive", "err", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		logging.FromContext(r.Context()).Error("Error writing export archive", "err", err)
+	}
+}
+
+func (h *AuthDbDeps) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	export, err := h.privacy.ExportUser(r.Context(), caller(r).OrgID, caller(r).UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeExport(w, r, export)
+}
+
+type eraseAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// eraseCurrentUserHandler erases the account of the caller, the password is
+// asked again since the operation can't be undone
+func (h *AuthDbDeps) eraseCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+	var req eraseAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	var current string
+	if err := h.db.QueryRowContext(r.Context(), "SELECT password FROM users WHERE id = $1", userID).Scan(&current); err != nil {
+		writeLookupError(w, r, err, service.ErrUserNotFound)
+		return
+	}
+	if !h.checkLockout(w, r, userID) {
+		return
+	}
+	if current != req.Password {
+		h.recordFailure(r, userID)
+		apierror.Error(w, "Текущий пароль неверен", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.privacy.EraseUser(r.Context(), caller(r).OrgID, userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account erased"})
+}
+
+func (h *handlerDeps) exportUserHandler(w http.ResponseWriter, r *http.Request) {
+	export, err := h.privacy.ExportUser(r.Context(), caller(r).OrgID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeExport(w, r, export)
+}
+
+// eraseUserHandler erases a user on their behalf, admins erase their own
+// account through /auth/me/erase
+func (h *handlerDeps) eraseUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	if userID == caller(r).UserID {
+		apierror.Error(w, "Use /auth/me/erase to erase your own account", http.StatusBadRequest)
+		return
+	}
+	if !h.manageableUser(w, r, userID) {
+		return
+	}
+	if err := h.privacy.EraseUser(r.Context(), caller(r).OrgID, userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User erased"})
+}
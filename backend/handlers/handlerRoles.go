@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Role handlers
+
+func (h *handlerDeps) getRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roles.GetRoles(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+func (h *handlerDeps) getPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AllPermissions)
+}
+
+func (h *handlerDeps) saveRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.roles.SaveRole(r.Context(), models.Role{
+		Name:        mux.Vars(r)["name"],
+		Description: req.Description,
+		Permissions: req.Permissions,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+func (h *handlerDeps) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.roles.DeleteRole(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role deleted"})
+}
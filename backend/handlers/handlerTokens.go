@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Personal access token handlers
+
+// sessionOnly rejects requests authenticated with a personal access token,
+// so a leaked token can't be used to mint or revoke other tokens
+func sessionOnly(w http.ResponseWriter, r *http.Request) bool {
+	if caller(r).TokenID != "" {
+		apierror.Error(w, "Tokens can only be managed from a login session", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *AuthDbDeps) getTokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	tokens, err := h.tokens.GetTokens(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (h *AuthDbDeps) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !sessionOnly(w, r) {
+		return
+	}
+	userID := caller(r).UserID
+
+	var req models.CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.tokens.CreateToken(r.Context(), userID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+func (h *AuthDbDeps) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !sessionOnly(w, r) {
+		return
+	}
+	userID := caller(r).UserID
+	tokenID := mux.Vars(r)["id"]
+
+	err := h.tokens.RevokeToken(r.Context(), userID, tokenID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked"})
+}
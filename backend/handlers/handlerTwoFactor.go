@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/metrics"
+	"belykh-ik/taskflow/models"
+	"belykh-ik/taskflow/service"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+)
+
+// Two-factor authentication handlers
+
+const twoFactorAudience = "2fa-challenge"
+
+// writeTwoFactorChallenge answers a successful password check with a short-lived
+// challenge token that can only be traded for a session at /auth/login/2fa
+func (h *AuthDbDeps) writeTwoFactorChallenge(w http.ResponseWriter, r *http.Request, userID string, enrollmentRequired bool) {
+	challenge, err := h.twoFactorChallenge(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TwoFactorChallengeResponse{
+		TwoFactorRequired:  true,
+		EnrollmentRequired: enrollmentRequired,
+		ChallengeToken:     challenge,
+	})
+}
+
+// twoFactorChallenge signs the challenge token of a user who passed the first
+// factor, by password or single sign-on
+func (h *AuthDbDeps) twoFactorChallenge(userID string) (string, error) {
+	claims := &models.Claims{
+		UserID: userID,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  twoFactorAudience,
+			ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.conf.JWT.Secret)
+}
+
+func (h *AuthDbDeps) parseTwoFactorChallenge(challenge string) (string, error) {
+	claims := &models.Claims{}
+	token, err := jwt.ParseWithClaims(challenge, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return h.conf.JWT.Secret, nil
+	})
+	if err != nil || !token.Valid || claims.Audience != twoFactorAudience || claims.UserID == "" {
+		return "", errors.New("invalid or expired challenge")
+	}
+	return claims.UserID, nil
+}
+
+func (h *AuthDbDeps) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	userID, err := h.parseTwoFactorChallenge(req.ChallengeToken)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	status, err := h.twoFactor.GetStatus(r.Context(), userID)
+	if err != nil {
+		apierror.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	if !h.checkLockout(w, r, userID) {
+		metrics.LoginFailures.WithLabelValues(metrics.LoginLocked).Inc()
+		return
+	}
+
+	// Accounts that are required to use 2FA but haven't enrolled yet finish
+	// the enrollment with their first code
+	var recoveryCodes []string
+	if status.Enabled {
+		err = h.twoFactor.Verify(r.Context(), userID, req.Code, req.RecoveryCode)
+	} else {
+		recoveryCodes, err = h.twoFactor.ConfirmEnrollment(r.Context(), userID, req.Code)
+	}
+	if errors.Is(err, service.ErrInvalidTwoFactorCode) || errors.Is(err, service.ErrTwoFactorNotPending) {
+		h.recordFailure(r, userID)
+		metrics.LoginFailures.WithLabelValues(metrics.LoginBadSecondFactor).Inc()
+		apierror.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = h.lockout.RecordSuccess(r.Context(), userID); err != nil {
+		logging.FromContext(r.Context()).Error("Error resetting failed logins", "err", err)
+	}
+
+	user, err := h.loadUser(r.Context(), userID)
+	if err != nil {
+		apierror.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	if !user.Active {
+		apierror.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
+	tokenString, err := h.issueToken(*user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AuthResponse{
+		Token:         tokenString,
+		User:          *user,
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func (h *AuthDbDeps) loginEnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	userID, err := h.parseTwoFactorChallenge(req.ChallengeToken)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := h.twoFactor.BeginEnrollment(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (h *AuthDbDeps) getTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := caller(r).UserID
+
+	status, err := h.twoFactor.GetStatus(r.Context(), userID)
+	if err != nil {
+		writeLookupError(w, r, err, service.ErrUserNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *AuthDbDeps) setupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !sessionOnly(w, r) {
+		return
+	}
+	userID := caller(r).UserID
+
+	enrollment, err := h.twoFactor.BeginEnrollment(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+type twoFactorCodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+func (h *AuthDbDeps) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !sessionOnly(w, r) {
+		return
+	}
+	userID := caller(r).UserID
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.twoFactor.ConfirmEnrollment(r.Context(), userID, req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recoveryCodes": codes})
+}
+
+func (h *AuthDbDeps) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !sessionOnly(w, r) {
+		return
+	}
+	userID := caller(r).UserID
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.Password == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var current string
+	if err := h.db.QueryRowContext(r.Context(), "SELECT password FROM users WHERE id = $1", userID).Scan(&current); err != nil {
+		writeLookupError(w, r, err, service.ErrUserNotFound)
+		return
+	}
+	if !h.checkLockout(w, r, userID) {
+		return
+	}
+	if current != req.Password {
+		h.recordFailure(r, userID)
+		apierror.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if err := h.twoFactor.Verify(r.Context(), userID, req.Code, ""); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err := h.twoFactor.Disable(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+func (h *AuthDbDeps) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if !sessionOnly(w, r) {
+		return
+	}
+	userID := caller(r).UserID
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := h.twoFactor.Verify(r.Context(), userID, req.Code, ""); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recoveryCodes": codes})
+}
+
+// Admin two-factor handlers
+
+type updateUserTwoFactorRequest struct {
+	Required bool `json:"required"`
+}
+
+func (h *handlerDeps) updateUserTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	userID := mux.Vars(r)["id"]
+	if !h.manageableUser(w, r, userID) {
+		return
+	}
+
+	var req updateUserTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.twoFactor.SetRequired(r.Context(), orgID, userID, req.Required)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor requirement updated"})
+}
+
+func (h *handlerDeps) resetUserTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := caller(r).OrgID
+	userID := mux.Vars(r)["id"]
+	if !h.manageableUser(w, r, userID) {
+		return
+	}
+
+	err := h.twoFactor.Reset(r.Context(), orgID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication reset"})
+}
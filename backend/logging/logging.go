@@ -0,0 +1,122 @@
+// Package logging configures the structured logger and carries the request
+// id, route and user of a request so every log line can be correlated
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup makes a JSON or text slog handler at the given level the default
+// logger, the standard log package is routed through it as well
+func Setup(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected json or text", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Fatal logs the error and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// RequestInfo is filled while a request goes through the middlewares, the
+// route and user are only known once the router and auth ran
+type RequestInfo struct {
+	ID string
+
+	mu     sync.Mutex
+	route  string
+	userID string
+}
+
+// Route returns the matched route template, empty when nothing matched
+func (i *RequestInfo) Route() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.route
+}
+
+// UserID returns the authenticated user, empty for anonymous requests
+func (i *RequestInfo) UserID() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.userID
+}
+
+type requestInfoKey struct{}
+
+// WithRequest starts tracking a request with the given id
+func WithRequest(ctx context.Context, id string) (context.Context, *RequestInfo) {
+	info := &RequestInfo{ID: id}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+func requestInfo(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// RequestID returns the id of the request, empty outside of a request
+func RequestID(ctx context.Context) string {
+	if info := requestInfo(ctx); info != nil {
+		return info.ID
+	}
+	return ""
+}
+
+// SetRoute records the matched route template of the request
+func SetRoute(ctx context.Context, route string) {
+	if info := requestInfo(ctx); info != nil {
+		info.mu.Lock()
+		info.route = route
+		info.mu.Unlock()
+	}
+}
+
+// SetUser records the authenticated user of the request
+func SetUser(ctx context.Context, userID string) {
+	if info := requestInfo(ctx); info != nil {
+		info.mu.Lock()
+		info.userID = userID
+		info.mu.Unlock()
+	}
+}
+
+// FromContext returns the default logger annotated with the request id, user
+// and trace of the request
+func FromContext(ctx context.Context) *slog.Logger {
+	info := requestInfo(ctx)
+	if info == nil {
+		return slog.Default()
+	}
+	logger := slog.Default().With("request_id", info.ID)
+	if userID := info.UserID(); userID != "" {
+		logger = logger.With("user_id", userID)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return logger
+}
@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+)
+
+// Sender delivers transactional emails such as password resets
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// LogSender writes emails to the log instead of sending them, used when no
+// SMTP server is configured (local development). Bodies carry reset and
+// invitation links, they are only logged at debug level
+type LogSender struct{}
+
+func (LogSender) Send(to, subject, body string) error {
+	slog.Info("Email not sent, SMTP is not configured", "to", to, "subject", subject)
+	slog.Debug("Email body", "to", to, "body", body)
+	return nil
+}
+
+// SMTPSender sends plain text emails through an SMTP server
+type SMTPSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s SMTPSender) Send(to, subject, body string) error {
+	// Guard against header injection through user supplied addresses
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header value")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	// Headers are ASCII only, the Russian subjects are encoded words
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(body)
+
+	// The envelope sender has to be a bare address
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, []string{to}, []byte(msg.String()))
+}
+
+// NewSender returns an SMTP sender when an address is configured and a log
+// sender otherwise
+func NewSender(addr, from, username, password string) Sender {
+	if addr == "" {
+		return LogSender{}
+	}
+	return SMTPSender{Addr: addr, From: from, Username: username, Password: password}
+}
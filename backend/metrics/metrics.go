@@ -0,0 +1,177 @@
+// Package metrics exposes Prometheus metrics of the HTTP server, the database
+// pool and the task activity
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "taskflow"
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// TasksCreated counts created tasks
+	TasksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Tasks created.",
+	})
+
+	// TasksCompleted counts tasks moved into the last column of their board
+	TasksCompleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_completed_total",
+		Help:      "Tasks moved into the last column of their board.",
+	})
+
+	// NotificationsSent counts notifications created for users
+	NotificationsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_sent_total",
+		Help:      "In-app notifications created.",
+	})
+
+	// LoginFailures counts refused logins by reason
+	LoginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Refused logins by reason.",
+	}, []string{"reason"})
+)
+
+// Reasons of LoginFailures
+const (
+	LoginUnknownUser     = "unknown_user"
+	LoginBadPassword     = "bad_password"
+	LoginLocked          = "locked"
+	LoginDeactivated     = "deactivated"
+	LoginBadSecondFactor = "bad_second_factor"
+)
+
+// Register adds the metrics to the default registry, including the pool stats
+// of the database and the tasks per column read at scrape time
+func Register(db *sql.DB) {
+	prometheus.MustRegister(
+		requests,
+		requestDuration,
+		TasksCreated,
+		TasksCompleted,
+		NotificationsSent,
+		LoginFailures,
+		collectors.NewDBStatsCollector(db, "postgres"),
+		&taskCollector{db: db},
+	)
+}
+
+// Handler serves the metrics, behind a bearer token when one is set
+func Handler(token string) http.Handler {
+	handler := promhttp.Handler()
+	if token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			apierror.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Instrument records the count and latency of the requests served by the
+// router, labeled with the route template so ids don't blow up the series
+func Instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		logging.SetRoute(r.Context(), route)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		router.ServeHTTP(recorder, r)
+
+		status := strconv.Itoa(recorder.status)
+		requests.WithLabelValues(r.Method, route, status).Inc()
+		requestDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+var tasksDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "tasks"),
+	"Tasks per board and column.",
+	[]string{"board", "column"}, nil,
+)
+
+// taskCollector counts the tasks per column when scraped
+type taskCollector struct {
+	db *sql.DB
+}
+
+func (c *taskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tasksDesc
+}
+
+func (c *taskCollector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := c.db.Query("SELECT board_id, state, COUNT(*) FROM tasks GROUP BY board_id, state")
+	if err != nil {
+		slog.Error("Error collecting task metrics", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var boardID, count int
+		var state string
+		if err := rows.Scan(&boardID, &state, &count); err != nil {
+			slog.Error("Error collecting task metrics", "err", err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(tasksDesc, prometheus.GaugeValue, float64(count), strconv.Itoa(boardID), state)
+	}
+}
@@ -5,57 +5,186 @@ import (
 	"net/http"
 	"strings"
 
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
 	"belykh-ik/taskflow/models"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func AuthMiddleware(config *models.Config, next http.HandlerFunc) http.HandlerFunc {
+// TokenAuthenticator resolves personal access tokens to claims
+type TokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (*models.Claims, error)
+}
+
+// PermissionResolver returns the permissions granted to a role
+type PermissionResolver interface {
+	Permissions(ctx context.Context, role string) (map[string]bool, error)
+}
+
+// UserChecker reports whether a user may still use the API
+type UserChecker interface {
+	IsActive(ctx context.Context, userID string) (bool, error)
+}
+
+// Auth authenticates requests and authorizes them against role permissions
+type Auth struct {
+	config *models.Config
+	tokens TokenAuthenticator
+	roles  PermissionResolver
+	users  UserChecker
+}
+
+func NewAuth(config *models.Config, tokens TokenAuthenticator, roles PermissionResolver, users UserChecker) *Auth {
+	return &Auth{
+		config: config,
+		tokens: tokens,
+		roles:  roles,
+		users:  users,
+	}
+}
+
+// Require authenticates the request and checks that the user has at least one
+// of the permissions. Without permissions only authentication is required
+func (a *Auth) Require(next http.HandlerFunc, permissions ...string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		claims, status, msg := a.authenticate(r)
+		if claims == nil {
+			apierror.Error(w, msg, status)
 			return
 		}
+		logging.SetUser(r.Context(), claims.UserID)
 
-		// Extract token from "Bearer <token>"
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+		granted, err := a.roles.Permissions(r.Context(), claims.Role)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error loading permissions", "err", err)
+			apierror.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
+		// Super-admins have every permission in their organization and manage
+		// the organizations themselves
+		if claims.SuperAdmin {
+			granted = make(map[string]bool, len(models.AllPermissions)+1)
+			for _, p := range models.AllPermissions {
+				granted[p] = true
+			}
+			granted[models.PermOrgManage] = true
+		}
+		// Admin permissions have to be granted to a token explicitly
+		if claims.TokenID != "" && !hasScope(claims.Scopes, models.ScopeAdmin) {
+			restricted := make(map[string]bool, len(granted))
+			for p := range granted {
+				restricted[p] = true
+			}
+			for _, p := range models.AdminPermissions {
+				delete(restricted, p)
+			}
+			granted = restricted
+		}
 
-		tokenString := tokenParts[1]
+		if len(permissions) > 0 {
+			allowed := false
+			for _, p := range permissions {
+				if granted[p] {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				apierror.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+		}
 
-		// Parse and validate token
-		claims := &models.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return config.JWT_SECRET, nil
+		// Call the next handler with the caller in the context
+		ctx := WithIdentity(r.Context(), &Identity{
+			UserID:      claims.UserID,
+			OrgID:       claims.OrgID,
+			Role:        claims.Role,
+			SuperAdmin:  claims.SuperAdmin,
+			TokenID:     claims.TokenID,
+			Permissions: granted,
 		})
+		next(w, r.WithContext(ctx))
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
+// HasPermission reports whether the authenticated user has the permission
+func HasPermission(r *http.Request, permission string) bool {
+	identity := IdentityFrom(r.Context())
+	return identity != nil && identity.Permissions[permission]
+}
+
+// authenticate returns the claims of the request or the status and message
+// to answer with
+func (a *Auth) authenticate(r *http.Request) (*models.Claims, int, string) {
+	// Get token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, http.StatusUnauthorized, "Authorization header required"
+	}
+
+	// Extract token from "Bearer <token>"
+	tokenParts := strings.Split(authHeader, " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return nil, http.StatusUnauthorized, "Invalid authorization format"
+	}
+
+	tokenString := tokenParts[1]
+
+	if a.tokens != nil && strings.HasPrefix(tokenString, models.TokenPrefix) {
+		// Personal access token
+		claims, err := a.tokens.AuthenticateToken(r.Context(), tokenString)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "Invalid or expired token"
+		}
+		if !tokenAllows(claims, r.Method) {
+			return nil, http.StatusForbidden, "Token scope does not allow this request"
 		}
+		return claims, 0, ""
+	}
 
-		// Add user ID and role to request context
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "userId", claims.UserID)
-		ctx = context.WithValue(ctx, "role", claims.Role)
+	// Parse and validate JWT
+	claims := &models.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.config.JWT.Secret, nil
+	})
 
-		// Call the next handler with the updated context
-		next(w, r.WithContext(ctx))
+	// Tokens with an audience (login challenges, SSO flow state) are not
+	// sessions. Sessions issued before organizations have to log in again
+	if err != nil || !token.Valid || claims.UserID == "" || claims.OrgID == 0 || claims.Audience != "" {
+		return nil, http.StatusUnauthorized, "Invalid or expired token"
 	}
+
+	// Sessions end as soon as the user is deactivated or deleted
+	if a.users != nil {
+		active, err := a.users.IsActive(r.Context(), claims.UserID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error checking user", "err", err)
+			return nil, http.StatusInternalServerError, "Database error"
+		}
+		if !active {
+			return nil, http.StatusUnauthorized, "Account is deactivated"
+		}
+	}
+	return claims, 0, ""
 }
 
-func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		role := r.Context().Value("role").(string)
-		if role != "admin" {
-			http.Error(w, "Admin access required", http.StatusForbidden)
-			return
+// tokenAllows checks the token scopes against the request method
+func tokenAllows(claims *models.Claims, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return hasScope(claims.Scopes, models.ScopeRead) || hasScope(claims.Scopes, models.ScopeWrite)
+	default:
+		return hasScope(claims.Scopes, models.ScopeWrite)
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
 		}
-		next(w, r)
 	}
+	return false
 }
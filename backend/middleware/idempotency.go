@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/models"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are stored with the response and sent again on replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// IdempotencyStore keeps the responses of requests sent with an
+// Idempotency-Key
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, userID, key, fingerprint string) (*models.IdempotentResponse, error)
+	Save(ctx context.Context, userID, key string, response models.IdempotentResponse) error
+	Release(ctx context.Context, userID, key string) error
+}
+
+// Idempotent makes retries of a request sent with the same Idempotency-Key
+// get the response of the first request instead of running again. Keys are
+// per user, reusing one with another method, path, query or body is refused.
+// Server errors and responses that couldn't be stored release the key so the
+// retry runs again. Requests without the header are passed through
+func Idempotent(store IdempotencyStore) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			identity := IdentityFrom(r.Context())
+			if key == "" || identity == nil {
+				next(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				apierror.Write(w, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key is too long", nil)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxBytes *http.MaxBytesError
+				if errors.As(err, &maxBytes) {
+					apierror.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				apierror.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.New()
+			io.WriteString(sum, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+			sum.Write(body)
+			fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+			stored, err := store.Reserve(r.Context(), identity.UserID, key, fingerprint)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Error reserving idempotency key", "err", err)
+				apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if stored != nil {
+				replay(w, stored, fingerprint)
+				return
+			}
+
+			recorder := &responseRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+			next(recorder, r)
+
+			// The response is stored even when the client went away, that's
+			// when it retries
+			ctx := context.WithoutCancel(r.Context())
+			if recorder.status >= http.StatusInternalServerError {
+				if err := store.Release(ctx, identity.UserID, key); err != nil {
+					logging.FromContext(ctx).Error("Error releasing idempotency key", "err", err)
+				}
+				return
+			}
+			response := models.IdempotentResponse{
+				Status:  recorder.status,
+				Headers: make(map[string]string),
+				Body:    recorder.body.Bytes(),
+			}
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					response.Headers[name] = value
+				}
+			}
+			if err := store.Save(ctx, identity.UserID, key, response); err != nil {
+				logging.FromContext(ctx).Error("Error storing idempotent response", "err", err)
+				// A key left reserved would answer every retry with 409
+				if err := store.Release(ctx, identity.UserID, key); err != nil {
+					logging.FromContext(ctx).Error("Error releasing idempotency key", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// replay answers a retry with the stored response
+func replay(w http.ResponseWriter, stored *models.IdempotentResponse, fingerprint string) {
+	if stored.Fingerprint != fingerprint {
+		apierror.Write(w, http.StatusUnprocessableEntity, "idempotency_key_reused",
+			"Idempotency-Key was already used for a different request", nil)
+		return
+	}
+	if stored.Status == 0 {
+		apierror.Write(w, http.StatusConflict, "idempotency_key_in_use",
+			"A request with this Idempotency-Key is still in progress", nil)
+		return
+	}
+	for name, value := range stored.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// responseRecorder keeps a copy of the response body next to its status
+type responseRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
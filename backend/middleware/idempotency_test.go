@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"belykh-ik/taskflow/models"
+)
+
+// memoryIdempotencyStore keeps the keys of the test in a map, failSave makes
+// Save fail
+type memoryIdempotencyStore struct {
+	responses map[string]models.IdempotentResponse
+	failSave  bool
+}
+
+func (m *memoryIdempotencyStore) Reserve(ctx context.Context, userID, key, fingerprint string) (*models.IdempotentResponse, error) {
+	if stored, ok := m.responses[userID+" "+key]; ok {
+		return &stored, nil
+	}
+	m.responses[userID+" "+key] = models.IdempotentResponse{Fingerprint: fingerprint}
+	return nil, nil
+}
+
+func (m *memoryIdempotencyStore) Save(ctx context.Context, userID, key string, response models.IdempotentResponse) error {
+	if m.failSave {
+		return errors.New("save failed")
+	}
+	response.Fingerprint = m.responses[userID+" "+key].Fingerprint
+	m.responses[userID+" "+key] = response
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Release(ctx context.Context, userID, key string) error {
+	if m.responses[userID+" "+key].Status == 0 {
+		delete(m.responses, userID+" "+key)
+	}
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		name     string
+		failSave bool
+		retry    string
+		status   int
+		runs     int
+	}{
+		{"replayed", false, "/api/tasks?boardId=1", http.StatusCreated, 1},
+		{"other query", false, "/api/tasks?boardId=2", http.StatusUnprocessableEntity, 1},
+		{"save failed", true, "/api/tasks?boardId=1", http.StatusCreated, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryIdempotencyStore{responses: map[string]models.IdempotentResponse{}, failSave: tt.failSave}
+			runs := 0
+			handler := Idempotent(store)(func(w http.ResponseWriter, r *http.Request) {
+				runs++
+				w.WriteHeader(http.StatusCreated)
+			})
+			send := func(target string) int {
+				r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"title":"Task"}`))
+				r.Header.Set(idempotencyKeyHeader, "key")
+				r = r.WithContext(WithIdentity(r.Context(), &Identity{UserID: "user"}))
+				w := httptest.NewRecorder()
+				handler(w, r)
+				return w.Code
+			}
+
+			if status := send("/api/tasks?boardId=1"); status != http.StatusCreated {
+				t.Fatalf("first status = %d", status)
+			}
+			if status := send(tt.retry); status != tt.status {
+				t.Errorf("retry status = %d, want %d", status, tt.status)
+			}
+			if runs != tt.runs {
+				t.Errorf("handler ran %d times, want %d", runs, tt.runs)
+			}
+		})
+	}
+}
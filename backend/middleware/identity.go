@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	UserID     string
+	OrgID      int
+	Role       string
+	SuperAdmin bool
+	// TokenID is set when the request uses a personal access token
+	TokenID string
+	// Permissions are the permissions granted to the caller, narrowed by the
+	// token scopes
+	Permissions map[string]bool
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of the context carrying the identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity set by Auth.Require, nil when the request
+// wasn't authenticated
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"belykh-ik/taskflow/apierror"
+	"belykh-ik/taskflow/models"
+)
+
+// maxBuckets bounds the clients a limiter tracks between cleanups
+const maxBuckets = 100000
+
+// overflowKey is the bucket shared by new clients while the limiter is full
+const overflowKey = "overflow"
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is an in-memory token bucket limiter keyed by client
+type RateLimiter struct {
+	limit     models.RateLimit
+	proxyHops int
+	// idle is how long a bucket takes to refill, after that it is forgotten
+	idle time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewRateLimiter creates a limiter, proxyHops is the number of trusted proxies
+// in front of the server and 0 ignores X-Forwarded-For
+func NewRateLimiter(limit models.RateLimit, proxyHops int) *RateLimiter {
+	l := &RateLimiter{
+		limit:     limit,
+		proxyHops: proxyHops,
+		buckets:   make(map[string]*bucket),
+	}
+	if limit.Rate > 0 {
+		l.idle = time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+		go l.cleanup()
+	}
+	return l
+}
+
+// Allow takes a token for the key and otherwise returns how long until the
+// next token is available
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok && len(l.buckets) >= maxBuckets {
+		l.sweep(now, l.idle)
+		if len(l.buckets) >= maxBuckets {
+			key = overflowKey
+			b, ok = l.buckets[key]
+		}
+	}
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanup drops buckets that have been full long enough to be forgotten
+func (l *RateLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		l.sweep(time.Now(), l.idle+time.Minute)
+		l.mu.Unlock()
+	}
+}
+
+// sweep drops the buckets unused for longer than idle, a bucket that refilled
+// is the same as a new one. l.mu is held
+func (l *RateLimiter) sweep(now time.Time, idle time.Duration) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *RateLimiter) reject(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	apierror.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// Limit limits a route per authenticated user, falling back to the client IP
+// for anonymous routes
+func (l *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + ClientIP(r, l.proxyHops)
+		if identity := IdentityFrom(r.Context()); identity != nil {
+			key = "user:" + identity.UserID
+		}
+		if ok, wait := l.Allow(key); !ok {
+			l.reject(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// LimitIP limits every request of a client IP
+func (l *RateLimiter) LimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow("ip:" + ClientIP(r, l.proxyHops)); !ok {
+			l.reject(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the request IP. Behind proxyHops trusted proxies it is the
+// X-Forwarded-For entry the outermost proxy appended, the entries before it
+// come from the client and can be forged
+func ClientIP(r *http.Request, proxyHops int) string {
+	if proxyHops > 0 {
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(header, ",")...)
+		}
+		if len(entries) >= proxyHops {
+			if ip := net.ParseIP(strings.TrimSpace(entries[len(entries)-proxyHops])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"bytes"
+	"encoding/json"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -8,34 +10,398 @@ import (
 
 // Config Db
 type Config struct {
-	DSN        string
-	PORT       string
-	JWT_SECRET []byte
+	Database DatabaseConfig
+	Server   ServerConfig
+	CORS     CORSConfig
+	JWT      JWTConfig
+	Log      LogConfig
+	Tracing  TracingConfig
+	// MetricsToken protects /metrics with a bearer token when set
+	MetricsToken string
+	// PasswordLoginDisabled turns off password login and registration,
+	// leaving single sign-on as the only way to log in
+	PasswordLoginDisabled bool
+	// RequireVerifiedEmail refuses password login until the email is verified
+	RequireVerifiedEmail bool
+	// AppURL is the frontend base URL used in emailed links
+	AppURL string
+	Mail   MailConfig
+	OIDC   OIDCConfig
+	// ProxyHops is the number of trusted reverse proxies appending to
+	// X-Forwarded-For, 0 takes the client IP from the connection
+	ProxyHops  int
+	RateLimits RateLimitConfig
+	Lockout    LockoutConfig
+	// DefaultBoardRole is the role new users get on the default board, empty
+	// leaves them without access until they are invited
+	DefaultBoardRole string
+	// OpenRegistration lets anyone sign up at /api/auth/register, otherwise
+	// accounts are only created through invitations
+	OpenRegistration bool
+	// BootstrapAdmin creates the first super-admin at startup when set
+	BootstrapAdmin BootstrapAdminConfig
+	// BootstrapToken is generated at startup while there is no super-admin
+	// and allows creating one through /api/auth/bootstrap
+	BootstrapToken string
+}
+
+// DatabaseConfig configures the PostgreSQL connection pool, zero values keep
+// the database/sql defaults
+type DatabaseConfig struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout is how long startup waits for the database to come up
+	ConnectTimeout time.Duration
+	// AutoMigrate applies pending migrations at startup
+	AutoMigrate bool
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	Host string
+	Port int
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout are passed
+	// to http.Server, zero means no timeout
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// RequestTimeout is the deadline of a request's handler, its database
+	// calls are canceled once it passes. SlowRequestTimeout applies to board
+	// loads and exports instead. Zero means no deadline
+	RequestTimeout     time.Duration
+	SlowRequestTimeout time.Duration
+	// MaxBodyBytes limits request bodies, zero disables the limit
+	MaxBodyBytes   int64
+	MaxHeaderBytes int
+	TLS            TLSConfig
+	// ShutdownTimeout is how long in-flight requests get to finish after
+	// SIGTERM before the server closes them
+	ShutdownTimeout time.Duration
+	// IdempotencyKeyTTL is how long the response of a request sent with an
+	// Idempotency-Key is replayed to retries
+	IdempotencyKeyTTL time.Duration
+}
+
+// TLSConfig serves HTTPS when both files are set
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is how often the files are checked for a renewed
+	// certificate, zero loads them once at startup
+	ReloadInterval time.Duration
+}
+
+// Enabled reports whether the server listens with TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// TracingConfig configures the OpenTelemetry spans
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector URL
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of new traces that are recorded, traces
+	// started upstream follow the caller's decision
+	SampleRatio float64
+}
+
+// LogConfig configures the structured logger
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is json or text
+	Format string
+}
+
+// CORSConfig lists the origins allowed to call the API from a browser, "*"
+// allows any origin
+type CORSConfig struct {
+	AllowedOrigins []string
+}
+
+// JWTConfig configures the session tokens
+type JWTConfig struct {
+	Secret []byte
+	// Lifetime is how long a session token stays valid after login
+	Lifetime time.Duration
+}
+
+// BootstrapAdminConfig holds the credentials of the first super-admin
+type BootstrapAdminConfig struct {
+	Username string
+	Email    string
+	Password string
+}
+
+// RateLimit is a token bucket allowing Burst requests at once, refilled at
+// Rate tokens per second. A zero Rate disables limiting
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig holds the limits per route group
+type RateLimitConfig struct {
+	// Global applies per client IP to every request
+	Global RateLimit
+	// Auth applies to login, registration and password endpoints
+	Auth RateLimit
+	// Board applies per user to GET /api/board
+	Board RateLimit
+	// API applies per user to the other authenticated endpoints
+	API RateLimit
+}
+
+// LockoutConfig configures account lockout after failed logins, each
+// consecutive lockout doubles the delay up to MaxDelay
+type LockoutConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// MailConfig configures outgoing email, emails are logged when Addr is empty
+type MailConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the backend callback registered at the provider
+	RedirectURL string
+	// FrontendURL receives the issued JWT in the URL fragment after login
+	FrontendURL string
+	// RoleClaim names the ID token claim used for role mapping, e.g. "groups"
+	RoleClaim   string
+	RoleMapping []OIDCRoleMapping
+	DefaultRole string
+}
+
+// OIDCRoleMapping maps a value of the role claim to a SmartBoard role
+type OIDCRoleMapping struct {
+	Value string
+	Role  string
+}
+
+// Enabled reports whether single sign-on is configured
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
 }
 
 // User represents a user in the system
 type User struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	Password      string `json:"-"`
+	Role          string `json:"role"`
+	OrgID         int    `json:"orgId"`
+	SuperAdmin    bool   `json:"superAdmin"`
+	EmailVerified bool   `json:"emailVerified"`
+	// Active is false for deactivated users, who can't log in
+	Active       bool      `json:"active"`
+	PendingEmail string    `json:"pendingEmail,omitempty"`
+	OIDCSubject  string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// DeletedUserName is shown as the author of comments of deleted users
+const DeletedUserName = "Удаленный пользователь"
+
+// DeleteUserOptions controls what happens to the tasks of a deleted user
+type DeleteUserOptions struct {
+	// ReassignTo takes over the tasks on the boards it is a member of
+	ReassignTo string
+	// KeepState leaves the tasks in their column instead of the backlog
+	KeepState bool
+}
+
+// UserExport holds the personal data of a user for a data-subject request
+type UserExport struct {
+	ExportedAt    time.Time         `json:"exportedAt"`
+	Profile       User              `json:"profile"`
+	Comments      []ExportedComment `json:"comments"`
+	AssignedTasks []ExportedTask    `json:"assignedTasks"`
+	CreatedTasks  []ExportedTask    `json:"createdTasks"`
+	Notifications []Notification    `json:"notifications"`
+	Boards        []BoardSummary    `json:"boards"`
+	Groups        []ExportedGroup   `json:"groups"`
+	APITokens     []APIToken        `json:"apiTokens"`
+	SavedFilters  []SavedFilter     `json:"savedFilters"`
+}
+
+// ExportedComment is a comment written by the exported user
+type ExportedComment struct {
 	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	Role      string    `json:"role"`
+	TaskID    string    `json:"taskId"`
+	TaskTitle string    `json:"taskTitle"`
+	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
-// Task represents a task in the system
-type Task struct {
+// ExportedTask is a task assigned to or created by the exported user
+type ExportedTask struct {
 	ID          string    `json:"id"`
+	BoardID     int       `json:"boardId"`
+	BoardName   string    `json:"boardName"`
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	State       string    `json:"state"`
 	Priority    int       `json:"priority"`
-	Assignee    string    `json:"assignee,omitempty"`
-	Comments    []Comment `json:"comments,omitempty"`
+	Labels      []string  `json:"labels"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
+// ExportedGroup is a group the exported user belongs to
+type ExportedGroup struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// Task represents a task in the system
+type Task struct {
+	ID          string `json:"id"`
+	BoardID     int    `json:"boardId"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	Priority    int    `json:"priority"`
+	// Assignee is the first assignee, kept for clients that only know one
+	Assignee  string         `json:"assignee,omitempty"`
+	Assignees []TaskAssignee `json:"assignees"`
+	// AssigneeIDs sets the assignees when creating a task
+	AssigneeIDs []string  `json:"assigneeIds,omitempty"`
+	GroupID     string    `json:"groupId,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Labels      []string  `json:"labels"`
+	Comments    []Comment `json:"comments,omitempty"`
+	// CommentCount is set by the board view, also when comments are left out
+	CommentCount int `json:"commentCount,omitempty"`
+	// Version is bumped by every change to the task, it is the ETag of the task
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TaskAssignee is a user assigned to a task
+type TaskAssignee struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// Task priorities, 1 is the most urgent
+const (
+	PriorityHigh   = 1
+	PriorityMedium = 2
+	PriorityLow    = 3
+)
+
+// CreateTaskRequest represents the create task request body
+type CreateTaskRequest struct {
+	BoardID     int    `json:"boardId"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	// Priority defaults to PriorityLow
+	Priority *int `json:"priority"`
+	// Assignee is the single assignee of older clients, added to AssigneeIDs
+	Assignee    string   `json:"assignee"`
+	AssigneeIDs []string `json:"assigneeIds"`
+	GroupID     string   `json:"groupId"`
+	Labels      []string `json:"labels"`
+}
+
+// UpdateTaskRequest represents the update task request body, only the fields
+// present in the body are changed. "assignees" takes a list of user ids, the
+// older "assignee" a single id, null or an empty string clear them
+type UpdateTaskRequest struct {
+	Title       *string  `json:"title"`
+	Description *string  `json:"description"`
+	State       *string  `json:"state"`
+	Priority    *int     `json:"priority"`
+	Assignee    string   `json:"assignee"`
+	Assignees   []string `json:"assignees"`
+	GroupID     string   `json:"groupId"`
+	Labels      []string `json:"labels"`
+	// present has the fields of the body, null ones included
+	present map[string]bool
+}
+
+// UnmarshalJSON decodes the body and records which fields it has, unknown
+// fields are rejected
+func (u *UpdateTaskRequest) UnmarshalJSON(data []byte) error {
+	type fields UpdateTaskRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode((*fields)(u)); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	u.present = make(map[string]bool, len(raw))
+	for name := range raw {
+		u.present[name] = true
+	}
+	return nil
+}
+
+// Has tells whether the body has the field
+func (u UpdateTaskRequest) Has(field string) bool {
+	return u.present[field]
+}
+
+// StateOnly tells whether the update only moves the task to another column
+func (u UpdateTaskRequest) StateOnly() bool {
+	return len(u.present) == 1 && u.Has("state")
+}
+
+// AssigneeUpdate returns the assignees set by the update and whether it sets
+// them
+func (u UpdateTaskRequest) AssigneeUpdate() ([]string, bool) {
+	if u.Has("assignees") {
+		if u.Assignees == nil {
+			return []string{}, true
+		}
+		return u.Assignees, true
+	}
+	if u.Has("assignee") {
+		if u.Assignee == "" || u.Assignee == "null" {
+			return []string{}, true
+		}
+		return []string{u.Assignee}, true
+	}
+	return nil, false
+}
+
+// GroupUpdate returns the group set by the update and whether it sets one, an
+// empty group clears it
+func (u UpdateTaskRequest) GroupUpdate() (string, bool) {
+	return u.GroupID, u.Has("groupId")
+}
+
+// LabelUpdate returns the labels set by the update and whether it sets them,
+// null or an empty list clear them
+func (u UpdateTaskRequest) LabelUpdate() ([]string, bool) {
+	return u.Labels, u.Has("labels")
+}
+
 // Comment represents a comment on a task
 type Comment struct {
 	ID        string    `json:"id"`
@@ -62,15 +428,227 @@ type Column struct {
 
 // Board represents the entire kanban board
 type Board struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	Role        string            `json:"role"`
 	Tasks       map[string]Task   `json:"tasks"`
 	Columns     map[string]Column `json:"columns"`
 	ColumnOrder []string          `json:"columnOrder"`
+	// Version is bumped by every change to the board, its tasks and members,
+	// it is the ETag of the board
+	Version int `json:"version"`
+}
+
+// DefaultOrgID is the organization created with the database, open
+// registration and single sign-on add users to it
+const DefaultOrgID = 1
+
+// Organization is a tenant owning users, boards and settings
+type Organization struct {
+	ID        int         `json:"id"`
+	Name      string      `json:"name"`
+	Settings  OrgSettings `json:"settings"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// OrgSettings are the organization-level settings
+type OrgSettings struct {
+	// DefaultRole is the role of users joining the organization, member when empty
+	DefaultRole string `json:"defaultRole,omitempty"`
+	// DefaultBoardRole is the role new users get on the default board of the
+	// organization, the server setting applies when empty and "none" keeps
+	// them off the board
+	DefaultBoardRole string `json:"defaultBoardRole,omitempty"`
+	// RequireTwoFactor makes two-factor authentication mandatory for every user
+	RequireTwoFactor bool `json:"requireTwoFactor"`
+}
+
+// OrgUpdateRequest is the body of an organization update, fields left out
+// keep their value
+type OrgUpdateRequest struct {
+	Name     *string `json:"name"`
+	Settings struct {
+		DefaultRole      *string `json:"defaultRole"`
+		DefaultBoardRole *string `json:"defaultBoardRole"`
+		RequireTwoFactor *bool   `json:"requireTwoFactor"`
+	} `json:"settings"`
 }
 
+// Comment modes of the board view, the comments of large boards can be left
+// out or only counted
+const (
+	BoardCommentsAll   = "all"
+	BoardCommentsCount = "count"
+	BoardCommentsNone  = "none"
+)
+
+// BoardFilter narrows down the tasks of a board
+type BoardFilter struct {
+	// GroupID keeps the tasks assigned to the group or to one of its members
+	GroupID string
+	// Comments is one of the BoardComments modes, empty means all
+	Comments string
+	// Query keeps the tasks matching a task query, see service.TaskQuery,
+	// for the user UserID
+	Query  string
+	UserID string
+}
+
+// Sort orders of the task list
+const (
+	TaskSortCreated  = "created"
+	TaskSortUpdated  = "updated"
+	TaskSortPriority = "priority"
+)
+
+// Sort directions of the task list
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// Page sizes of the task list
+const (
+	DefaultTaskPageSize = 50
+	MaxTaskPageSize     = 200
+)
+
+// TaskListFilter selects the tasks of GET /api/tasks, zero values don't
+// filter
+type TaskListFilter struct {
+	// BoardID keeps the tasks of one board, otherwise every board the user
+	// can see is listed
+	BoardID    int
+	States     []string
+	AssigneeID string
+	CreatorID  string
+	// Labels keeps the tasks that have all of them
+	Labels      []string
+	PriorityMin int
+	PriorityMax int
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	UpdatedFrom time.Time
+	UpdatedTo   time.Time
+	// Text is searched in the title and the description
+	Text string
+	// Query is a task query, see service.TaskQuery. Its ORDER BY takes the
+	// place of Sort and Order
+	Query string
+	// Sort is one of the TaskSort orders and Order its direction, dates
+	// default to newest first and the priority to the highest first
+	Sort  string
+	Order string
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// TaskPage is a page of the task list
+type TaskPage struct {
+	Tasks []Task `json:"tasks"`
+	// NextCursor fetches the next page, empty on the last one
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// SavedFilter is a task query saved by a user, shared filters are visible to
+// everyone in the organization
+type SavedFilter struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	Shared    bool      `json:"shared"`
+	OwnerID   string    `json:"ownerId"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SavedFilterRequest creates or replaces a saved filter
+type SavedFilterRequest struct {
+	Name   string `json:"name"`
+	Query  string `json:"query"`
+	Shared bool   `json:"shared"`
+}
+
+// Group is a team of users of an organization, tasks can be assigned to it
+type Group struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	MemberCount int           `json:"memberCount"`
+	Members     []GroupMember `json:"members,omitempty"`
+	CreatedAt   time.Time     `json:"createdAt"`
+}
+
+// GroupMember represents a user in a group
+type GroupMember struct {
+	UserID   string    `json:"userId"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+// BoardSummary describes a board and the role of the current user on it
+type BoardSummary struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BoardMember represents a user with access to a board
+type BoardMember struct {
+	UserID   string    `json:"userId"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// BoardInvitation is a pending invitation to join a board
+type BoardInvitation struct {
+	ID        string    `json:"id"`
+	BoardID   int       `json:"boardId"`
+	BoardName string    `json:"boardName"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invitedBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIToken represents a personal access token owned by a user
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// TokenPrefix marks personal access tokens so they can be told apart from JWTs
+const TokenPrefix = "sbp_"
+
+// Personal access token scopes
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
 // Claims represents the JWT claims
 type Claims struct {
-	UserID string `json:"userId"`
-	Role   string `json:"role"`
+	UserID     string `json:"userId"`
+	Role       string `json:"role"`
+	OrgID      int    `json:"orgId"`
+	SuperAdmin bool   `json:"superAdmin,omitempty"`
+	// TokenID and Scopes are only set when the request is authenticated
+	// with a personal access token instead of a JWT
+	TokenID string   `json:"-"`
+	Scopes  []string `json:"-"`
 	jwt.StandardClaims
 }
 
@@ -87,8 +665,124 @@ type RegisterRequest struct {
 	Password string `json:"password"`
 }
 
+// UpdateProfileRequest represents the request body of a profile update,
+// fields left out stay unchanged
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+// CreateTokenRequest represents the personal access token request body
+type CreateTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// CreateTokenResponse contains the plain token, which is only shown once
+type CreateTokenResponse struct {
+	Token    string   `json:"token"`
+	APIToken APIToken `json:"apiToken"`
+}
+
+// ForgotPasswordRequest starts a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest completes a password reset with the emailed token
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// VerifyEmailRequest confirms an email address with the emailed token
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// Invitation is an emailed link to create an account in an organization
+// with a preassigned role
+type Invitation struct {
+	ID        string    `json:"id"`
+	OrgID     int       `json:"orgId"`
+	OrgName   string    `json:"orgName,omitempty"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invitedBy,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreateInvitationRequest represents the invitation request body
+type CreateInvitationRequest struct {
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	ExpiresInDays int    `json:"expiresInDays"`
+}
+
+// CreateInvitationResponse contains the invitation link, which is only shown once
+type CreateInvitationResponse struct {
+	Link       string     `json:"link"`
+	Invitation Invitation `json:"invitation"`
+}
+
+// AcceptInvitationRequest creates the invited account
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// BootstrapRequest creates the first super-admin with the setup token
+type BootstrapRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 // AuthResponse represents the authentication response
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token         string   `json:"token"`
+	User          User     `json:"user"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// TwoFactorChallengeResponse is returned by login instead of a session token
+// when the account needs a second factor
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired  bool   `json:"twoFactorRequired"`
+	EnrollmentRequired bool   `json:"enrollmentRequired"`
+	ChallengeToken     string `json:"challengeToken"`
+}
+
+// TwoFactorLoginRequest completes a login started with a challenge token
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// TwoFactorStatus describes the two-factor state of an account
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// TwoFactorEnrollment contains the TOTP secret for authenticator apps
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthUri"`
+}
+
+// IdempotentResponse is the stored response of a request sent with an
+// Idempotency-Key. Fingerprint identifies the request, Status is 0 while the
+// first request is still running
+type IdempotentResponse struct {
+	Fingerprint string
+	Status      int
+	Headers     map[string]string
+	Body        []byte
 }
@@ -0,0 +1,114 @@
+package models
+
+// Permissions checked by the authorization middleware
+const (
+	PermBoardView      = "board.view"
+	PermBoardConfigure = "board.configure"
+	// PermBoardManage manages members of a board, granted globally it gives
+	// owner access to every board of the organization
+	PermBoardManage = "board.manage"
+	PermTaskCreate  = "task.create"
+	PermTaskUpdate  = "task.update"
+	PermTaskMove    = "task.move"
+	PermTaskDelete  = "task.delete"
+	PermTaskComment = "task.comment"
+	PermUserView    = "user.view"
+	PermUserManage  = "user.manage"
+	PermGroupManage = "group.manage"
+	// PermRoleManage edits the role definitions shared by all organizations
+	PermRoleManage   = "role.manage"
+	PermOrgConfigure = "org.configure"
+	// PermOrgManage creates and manages organizations. It is only granted to
+	// super-admins and can't be part of a role
+	PermOrgManage = "org.manage"
+)
+
+// AllPermissions lists every known permission
+var AllPermissions = []string{
+	PermBoardView,
+	PermBoardConfigure,
+	PermBoardManage,
+	PermTaskCreate,
+	PermTaskUpdate,
+	PermTaskMove,
+	PermTaskDelete,
+	PermTaskComment,
+	PermUserView,
+	PermUserManage,
+	PermGroupManage,
+	PermRoleManage,
+	PermOrgConfigure,
+}
+
+// AdminPermissions can only be used through a personal access token that
+// has the admin scope
+var AdminPermissions = []string{
+	PermBoardManage,
+	PermUserManage,
+	PermRoleManage,
+	PermOrgConfigure,
+	PermOrgManage,
+}
+
+// Built-in roles
+const (
+	RoleViewer     = "viewer"
+	RoleMember     = "member"
+	RoleMaintainer = "maintainer"
+	RoleAdmin      = "admin"
+	// RoleUser is kept for accounts created before roles were configurable
+	// and has the member permissions
+	RoleUser = "user"
+)
+
+// Board roles, given per board through membership
+const (
+	BoardRoleOwner     = "owner"
+	BoardRoleEditor    = "editor"
+	BoardRoleCommenter = "commenter"
+	BoardRoleViewer    = "viewer"
+)
+
+// BoardRolePermissions lists what each board role allows on its board. A
+// request needs the permission both globally and on the board, except for
+// board.manage which the owner role grants by itself
+var BoardRolePermissions = map[string][]string{
+	BoardRoleViewer:    {PermBoardView},
+	BoardRoleCommenter: {PermBoardView, PermTaskComment},
+	BoardRoleEditor:    {PermBoardView, PermTaskComment, PermTaskMove, PermTaskCreate, PermTaskUpdate, PermTaskDelete},
+	BoardRoleOwner:     {PermBoardView, PermTaskComment, PermTaskMove, PermTaskCreate, PermTaskUpdate, PermTaskDelete, PermBoardConfigure, PermBoardManage},
+}
+
+// IsBoardRole reports whether the name is a board role
+func IsBoardRole(name string) bool {
+	_, ok := BoardRolePermissions[name]
+	return ok
+}
+
+// BoardRoleAllows reports whether the board role grants the permission
+func BoardRoleAllows(role, permission string) bool {
+	for _, p := range BoardRolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPermission reports whether the name is a known permission
+func IsPermission(name string) bool {
+	for _, p := range AllPermissions {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Role represents a named set of permissions
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	BuiltIn     bool     `json:"builtIn"`
+}
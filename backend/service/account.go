@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/mail"
+
+	"github.com/lib/pq"
+)
+
+// User action token purposes
+const (
+	purposePasswordReset = "password_reset"
+	purposeVerifyEmail   = "verify_email"
+)
+
+const (
+	passwordResetLifetime = time.Hour
+	verifyEmailLifetime   = 48 * time.Hour
+)
+
+var (
+	ErrInvalidActionToken = newError(KindValidation, "invalid_link", "invalid or expired link")
+	ErrEmailInUse         = newError(KindConflict, "email_in_use", "email already in use")
+)
+
+type AccountDeps struct {
+	db     *sql.DB
+	mailer mail.Sender
+	appURL string
+}
+
+func NewAccountDeps(db *sql.DB, mailer mail.Sender, appURL string) *AccountDeps {
+	return &AccountDeps{
+		db:     db,
+		mailer: mailer,
+		appURL: strings.TrimSuffix(appURL, "/"),
+	}
+}
+
+// createActionToken stores the hash of a new single-use token and returns
+// the plain token for the link
+func (a AccountDeps) createActionToken(ctx context.Context, userID, purpose, email string, lifetime time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	// Older links for the same purpose stop working
+	if _, err := a.db.ExecContext(ctx, `
+		UPDATE user_action_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose); err != nil {
+		return "", err
+	}
+
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO user_action_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, purpose, hashToken(token), email, time.Now().Add(lifetime))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeActionToken marks the token used and returns its user and email
+func (a AccountDeps) consumeActionToken(ctx context.Context, tx *sql.Tx, token, purpose string) (string, string, error) {
+	var userID, email string
+	err := tx.QueryRowContext(ctx, `
+		UPDATE user_action_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, email
+	`, hashToken(token), purpose).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return "", "", ErrInvalidActionToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return userID, email, nil
+}
+
+func (a AccountDeps) link(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", a.appURL, path, url.QueryEscape(token))
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to a user.
+// It doesn't report unknown addresses so accounts can't be enumerated: the
+// lookup and the email run after the request is answered, known and unknown
+// addresses take the same time
+func (a AccountDeps) RequestPasswordReset(ctx context.Context, email string) error {
+	ctx, span := tracer.Start(ctx, "AccountDeps.RequestPasswordReset")
+	defer span.End()
+
+	go a.sendPasswordReset(context.WithoutCancel(ctx), email)
+	return nil
+}
+
+func (a AccountDeps) sendPasswordReset(ctx context.Context, email string) {
+	log := logging.FromContext(ctx)
+
+	var userID, address string
+	err := a.db.QueryRowContext(ctx, "SELECT id, email FROM users WHERE lower(email) = lower($1)", email).Scan(&userID, &address)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Error("Error loading user for password reset", "err", err)
+		return
+	}
+
+	token, err := a.createActionToken(ctx, userID, purposePasswordReset, address, passwordResetLifetime)
+	if err != nil {
+		log.Error("Error creating password reset token", "err", err)
+		return
+	}
+
+	body := fmt.Sprintf("Для сброса пароля перейдите по ссылке:\n\n%s\n\nСсылка действительна в течение часа. Если вы не запрашивали сброс, просто проигнорируйте это письмо.",
+		a.link("/reset-password", token))
+	if err := a.mailer.Send(address, "Сброс пароля SmartBoard", body); err != nil {
+		log.Error("Error sending password reset email", "err", err)
+	}
+}
+
+func (a AccountDeps) ResetPassword(ctx context.Context, token, newPassword string) error {
+	ctx, span := tracer.Start(ctx, "AccountDeps.ResetPassword")
+	defer span.End()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, _, err := a.consumeActionToken(ctx, tx, token, purposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	// Receiving the reset email proves ownership of the address as well
+	// and lifts a lockout caused by guessing the old password
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET password = $1, email_verified = true, failed_logins = 0, lockouts = 0, locked_until = NULL
+		WHERE id = $2
+	`, newPassword, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SendEmailVerification emails a verification link for the given address,
+// which is either the current email or a requested new one
+func (a AccountDeps) SendEmailVerification(ctx context.Context, userID, email string) error {
+	ctx, span := tracer.Start(ctx, "AccountDeps.SendEmailVerification")
+	defer span.End()
+
+	token, err := a.createActionToken(ctx, userID, purposeVerifyEmail, email, verifyEmailLifetime)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Подтвердите адрес электронной почты, перейдя по ссылке:\n\n%s\n\nСсылка действительна в течение 48 часов.",
+		a.link("/verify-email", token))
+	if err := a.mailer.Send(email, "Подтверждение email SmartBoard", body); err != nil {
+		logging.FromContext(ctx).Error("Error sending verification email", "err", err)
+	}
+	return nil
+}
+
+// RequestEmailChange keeps the current email until the new one is verified
+func (a AccountDeps) RequestEmailChange(ctx context.Context, userID, email string) error {
+	ctx, span := tracer.Start(ctx, "AccountDeps.RequestEmailChange")
+	defer span.End()
+
+	var count int
+	err := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE lower(email) = lower($1) AND id <> $2", email, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailInUse
+	}
+	return a.SendEmailVerification(ctx, userID, email)
+}
+
+// VerifyEmail confirms the address from the link, switching the account to
+// it when the link was sent for an email change
+func (a AccountDeps) VerifyEmail(ctx context.Context, token string) error {
+	ctx, span := tracer.Start(ctx, "AccountDeps.VerifyEmail")
+	defer span.End()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, email, err := a.consumeActionToken(ctx, tx, token, purposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET email = $1, email_verified = true WHERE id = $2", email, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrEmailInUse
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,14 +1,33 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
+	"time"
 
+	"belykh-ik/taskflow/logging"
 	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrBoardNotFound = newError(KindNotFound, "board_not_found", "board not found")
+	ErrInvalidBoard  = newError(KindValidation, "invalid_board", "board name is required")
 )
 
+// defaultColumns are created on every new board
+var defaultColumns = []ColumnUpdate{
+	{ID: "backlog", Title: "Бэклог", Order: 1},
+	{ID: "inprogress", Title: "В работе", Order: 2},
+	{ID: "aprove", Title: "На подтверждении", Order: 3},
+	{ID: "done", Title: "Завершено", Order: 4},
+}
+
 type BoardDeps struct {
 	db *sql.DB
 }
@@ -25,25 +44,156 @@ type ColumnUpdate struct {
 	Order int    `json:"order"`
 }
 
-func (b BoardDeps) UpdateBoardColumns(columns []ColumnUpdate) error {
-	tx, err := b.db.Begin()
+// CreateBoard creates a board in the organization with the default columns,
+// owned by the user
+func (b BoardDeps) CreateBoard(ctx context.Context, orgID int, userID, name string) (*models.BoardSummary, error) {
+	ctx, span := tracer.Start(ctx, "BoardDeps.CreateBoard")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, ErrInvalidBoard
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	board := models.BoardSummary{Name: name, Role: models.BoardRoleOwner}
+	board.ID, board.CreatedAt, err = insertBoard(ctx, tx, orgID, userID, name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO board_members (board_id, user_id, role) VALUES ($1, $2, $3)
+	`, board.ID, userID, models.BoardRoleOwner); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &board, nil
+}
+
+// insertBoard creates a board with the default columns, createdBy may be nil
+func insertBoard(ctx context.Context, tx *sql.Tx, orgID int, createdBy interface{}, name string, isDefault bool) (int, time.Time, error) {
+	columnOrder := make([]string, len(defaultColumns))
+	for i, col := range defaultColumns {
+		columnOrder[i] = col.ID
+	}
+	columnOrderJSON, err := json.Marshal(columnOrder)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var id int
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO board_config (org_id, name, is_default, column_order, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, orgID, name, isDefault, string(columnOrderJSON), createdBy).Scan(&id, &createdAt)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	for _, col := range defaultColumns {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO board_columns (board_id, id, title, column_order)
+			VALUES ($1, $2, $3, $4)
+		`, id, col.ID, col.Title, col.Order); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	return id, createdAt, nil
+}
+
+// DefaultBoard returns the default board of the organization
+func (b BoardDeps) DefaultBoard(ctx context.Context, orgID int) (int, error) {
+	ctx, span := tracer.Start(ctx, "BoardDeps.DefaultBoard")
+	defer span.End()
+
+	var boardID int
+	err := b.db.QueryRowContext(ctx, "SELECT id FROM board_config WHERE org_id = $1 AND is_default", orgID).Scan(&boardID)
+	if err == sql.ErrNoRows {
+		return 0, ErrBoardNotFound
+	}
+	return boardID, err
+}
+
+// GetBoards lists the boards of the organization the user is a member of, or
+// all of them when all is set. The role is empty on boards the user isn't a
+// member of
+func (b BoardDeps) GetBoards(ctx context.Context, orgID int, userID string, all bool) ([]models.BoardSummary, error) {
+	ctx, span := tracer.Start(ctx, "BoardDeps.GetBoards")
+	defer span.End()
+
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT b.id, b.name, COALESCE(m.role, ''), b.created_at
+		FROM board_config b
+		LEFT JOIN board_members m ON m.board_id = b.id AND m.user_id = $2
+		WHERE b.org_id = $1 AND ($3 OR m.user_id IS NOT NULL)
+		ORDER BY b.id
+	`, orgID, userID, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boards := []models.BoardSummary{}
+	for rows.Next() {
+		var board models.BoardSummary
+		if err := rows.Scan(&board.ID, &board.Name, &board.Role, &board.CreatedAt); err != nil {
+			return nil, err
+		}
+		boards = append(boards, board)
+	}
+	return boards, rows.Err()
+}
+
+// UpdateBoardColumns sets the titles and the order of the columns. With
+// ifVersion the board must still be at one of these versions
+func (b BoardDeps) UpdateBoardColumns(ctx context.Context, orgID, boardID int, columns []ColumnUpdate, ifVersion []int) error {
+	ctx, span := tracer.Start(ctx, "BoardDeps.UpdateBoardColumns")
+	defer span.End()
+
+	tx, err := b.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	version, err := lockBoard(ctx, tx, orgID, boardID)
+	if err != nil {
+		return err
+	}
+	if err = matchVersion(version, ifVersion); err != nil {
+		return err
+	}
+
 	// Update column titles and order
-	for _, col := range columns {
-		_, err = tx.Exec(`
+	unknown := fieldErrors{}
+	for i, col := range columns {
+		result, err := tx.ExecContext(ctx, `
 			UPDATE board_columns 
 			SET title = $1, column_order = $2 
-			WHERE id = $3
-		`, col.Title, col.Order, col.ID)
+			WHERE board_id = $3 AND id = $4
+		`, strings.TrimSpace(col.Title), col.Order, boardID, col.ID)
 
 		if err != nil {
-			log.Printf("Error updating column %s: %v", col.ID, err)
+			logging.FromContext(ctx).Error("Error updating column", "board_id", boardID, "column", col.ID, "err", err)
 			return err
 		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			unknown.check(false, fmt.Sprintf("columns.%d.id", i), "unknown column")
+		}
+	}
+	if err = unknown.err(); err != nil {
+		return err
 	}
 
 	// Update board configuration with new column order
@@ -57,11 +207,11 @@ func (b BoardDeps) UpdateBoardColumns(columns []ColumnUpdate) error {
 		return err
 	}
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		UPDATE board_config 
-		SET column_order = $1, updated_at = NOW()
-		WHERE id = 1
-	`, string(columnOrderJSON))
+		SET column_order = $1
+		WHERE id = $2
+	`, string(columnOrderJSON), boardID)
 
 	if err != nil {
 		return err
@@ -70,12 +220,17 @@ func (b BoardDeps) UpdateBoardColumns(columns []ColumnUpdate) error {
 	return tx.Commit()
 }
 
-func (b BoardDeps) GetBoardColumns() ([]map[string]interface{}, error) {
-	rows, err := b.db.Query(`
-		SELECT id, title, column_order 
-		FROM board_columns 
-		ORDER BY column_order
-	`)
+func (b BoardDeps) GetBoardColumns(ctx context.Context, orgID, boardID int) ([]map[string]interface{}, error) {
+	ctx, span := tracer.Start(ctx, "BoardDeps.GetBoardColumns")
+	defer span.End()
+
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT c.id, c.title, c.column_order 
+		FROM board_columns c
+		JOIN board_config b ON b.id = c.board_id
+		WHERE c.board_id = $1 AND b.org_id = $2
+		ORDER BY c.column_order
+	`, boardID, orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -100,42 +255,73 @@ func (b BoardDeps) GetBoardColumns() ([]map[string]interface{}, error) {
 	return columns, nil
 }
 
-func (b BoardDeps) AddBoardColumn(title string) error {
+func (b BoardDeps) AddBoardColumn(ctx context.Context, orgID, boardID int, title string) error {
+	ctx, span := tracer.Start(ctx, "BoardDeps.AddBoardColumn")
+	defer span.End()
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = lockBoard(ctx, tx, orgID, boardID); err != nil {
+		return err
+	}
+
 	// Get the next order number
 	var maxOrder int
-	err := b.db.QueryRow("SELECT COALESCE(MAX(column_order), 0) FROM board_columns").Scan(&maxOrder)
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(column_order), 0) FROM board_columns WHERE board_id = $1", boardID).Scan(&maxOrder)
 	if err != nil {
 		return err
 	}
 
 	// Insert new column
-	_, err = b.db.Exec(`
-		INSERT INTO board_columns (id, title, column_order)
-		VALUES ($1, $2, $3)
-	`, fmt.Sprintf("column-%d", maxOrder+1), title, maxOrder+1)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO board_columns (board_id, id, title, column_order)
+		VALUES ($1, $2, $3, $4)
+	`, boardID, fmt.Sprintf("column-%d", maxOrder+1), title, maxOrder+1)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit()
 }
 
-func (b BoardDeps) DeleteBoardColumn(columnID string) error {
-	tx, err := b.db.Begin()
+func (b BoardDeps) DeleteBoardColumn(ctx context.Context, orgID, boardID int, columnID string) error {
+	ctx, span := tracer.Start(ctx, "BoardDeps.DeleteBoardColumn")
+	defer span.End()
+
+	tx, err := b.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Move all tasks from this column to backlog
-	_, err = tx.Exec(`
+	if _, err = lockBoard(ctx, tx, orgID, boardID); err != nil {
+		return err
+	}
+
+	// Move all tasks from this column to backlog, unassigned
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM task_assignees a
+		USING tasks t
+		WHERE a.task_id = t.id AND t.board_id = $1 AND t.state = $2
+	`, boardID, columnID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
 		UPDATE tasks 
-		SET state = 'backlog', assignee = NULL 
-		WHERE state = $1
-	`, columnID)
+		SET state = 'backlog', group_id = NULL, version = version + 1
+		WHERE board_id = $1 AND state = $2
+	`, boardID, columnID)
 	if err != nil {
 		return err
 	}
 
 	// Delete the column
-	_, err = tx.Exec("DELETE FROM board_columns WHERE id = $1", columnID)
+	_, err = tx.ExecContext(ctx, "DELETE FROM board_columns WHERE board_id = $1 AND id = $2", boardID, columnID)
 	if err != nil {
 		return err
 	}
@@ -143,32 +329,78 @@ func (b BoardDeps) DeleteBoardColumn(columnID string) error {
 	return tx.Commit()
 }
 
-func (b BoardDeps) GetBoard() (*models.Board, error) {
+// lockBoard locks the board for a change of the transaction and bumps its
+// version, returning the version before the change. Boards of other
+// organizations are reported as missing
+func lockBoard(ctx context.Context, tx *sql.Tx, orgID, boardID int) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `
+		UPDATE board_config SET version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND org_id = $2
+		RETURNING version - 1
+	`, boardID, orgID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrBoardNotFound
+	}
+	return version, err
+}
+
+// BoardVersion returns the version of a board of the organization, a cheap
+// check for conditional requests before loading the board
+func (b BoardDeps) BoardVersion(ctx context.Context, orgID, boardID int) (int, error) {
+	ctx, span := tracer.Start(ctx, "BoardDeps.BoardVersion")
+	defer span.End()
+
+	var version int
+	err := b.db.QueryRowContext(ctx, "SELECT version FROM board_config WHERE id = $1 AND org_id = $2", boardID, orgID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrBoardNotFound
+	}
+	return version, err
+}
+
+// GetBoard returns the board with its columns and the tasks matching the filter
+func (b BoardDeps) GetBoard(ctx context.Context, orgID, boardID int, filter models.BoardFilter) (*models.Board, error) {
+	ctx, span := tracer.Start(ctx, "BoardDeps.GetBoard")
+	defer span.End()
+
+	// The query is matched against the loaded tasks, they are all loaded with
+	// their assignees anyway
+	var query *TaskQuery
+	if filter.Query != "" {
+		var err error
+		if query, err = ParseTaskQuery(filter.Query); err != nil {
+			return nil, err
+		}
+	}
+
 	board := &models.Board{
+		ID:          boardID,
 		Tasks:       make(map[string]models.Task),
 		Columns:     make(map[string]models.Column),
 		ColumnOrder: make([]string, 0),
 	}
 
-	// Get column order from board_config
+	// Get name and column order from board_config
 	var columnOrderJSON string
-	err := b.db.QueryRow("SELECT column_order FROM board_config WHERE id = 1").Scan(&columnOrderJSON)
+	err := b.db.QueryRowContext(ctx, "SELECT name, column_order, version FROM board_config WHERE id = $1 AND org_id = $2", boardID, orgID).Scan(&board.Name, &columnOrderJSON, &board.Version)
+	if err == sql.ErrNoRows {
+		return nil, ErrBoardNotFound
+	}
 	if err != nil {
-		// If no config exists, use default order
-		board.ColumnOrder = []string{"backlog", "inprogress", "aprove", "done"}
-	} else {
-		err = json.Unmarshal([]byte(columnOrderJSON), &board.ColumnOrder)
-		if err != nil {
-			board.ColumnOrder = []string{"backlog", "inprogress", "aprove", "done"}
-		}
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(columnOrderJSON), &board.ColumnOrder); err != nil {
+		return nil, fmt.Errorf("column order of board %d: %w", boardID, err)
 	}
 
 	// Get all columns
-	rows, err := b.db.Query(`
+	rows, err := b.db.QueryContext(ctx, `
 		SELECT id, title, column_order 
 		FROM board_columns 
+		WHERE board_id = $1
 		ORDER BY column_order
-	`)
+	`, boardID)
 	if err != nil {
 		return nil, err
 	}
@@ -189,14 +421,26 @@ func (b BoardDeps) GetBoard() (*models.Board, error) {
 		}
 	}
 
-	// Get all tasks
-	taskRows, err := b.db.Query(`
-		SELECT t.id, t.title, t.description, t.state, t.priority, 
-		       COALESCE(u.username, '') as assignee, t.created_at, t.updated_at
+	assignees, err := taskAssignees(ctx, b.db, "WHERE t.board_id = $1", boardID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all tasks, a group filter keeps the tasks of the group and of its members
+	taskRows, err := b.db.QueryContext(ctx, `
+		SELECT t.id, t.board_id, t.title, t.description, t.state, t.priority, 
+		       COALESCE(g.id::text, ''), COALESCE(g.name, ''), t.labels, t.version, t.created_at, t.updated_at
 		FROM tasks t
-		LEFT JOIN users u ON t.assignee = u.id
+		LEFT JOIN groups g ON g.id = t.group_id
+		WHERE t.board_id = $1 AND (
+			$2::uuid IS NULL OR t.group_id = $2 OR EXISTS (
+				SELECT 1 FROM task_assignees a
+				JOIN group_members gm ON gm.user_id = a.user_id
+				WHERE a.task_id = t.id AND gm.group_id = $2
+			)
+		)
 		ORDER BY t.created_at DESC
-	`)
+	`, boardID, nullString(filter.GroupID))
 	if err != nil {
 		return nil, err
 	}
@@ -204,16 +448,16 @@ func (b BoardDeps) GetBoard() (*models.Board, error) {
 
 	for taskRows.Next() {
 		var task models.Task
-		var assignee sql.NullString
 
-		err := taskRows.Scan(&task.ID, &task.Title, &task.Description,
-			&task.State, &task.Priority, &assignee, &task.CreatedAt, &task.UpdatedAt)
+		err := taskRows.Scan(&task.ID, &task.BoardID, &task.Title, &task.Description,
+			&task.State, &task.Priority, &task.GroupID, &task.Group, pq.Array(&task.Labels), &task.Version, &task.CreatedAt, &task.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
 
-		if assignee.Valid {
-			task.Assignee = assignee.String
+		setAssignees(&task, assignees[task.ID])
+		if query != nil && !query.Match(&task, filter.UserID) {
+			continue
 		}
 
 		board.Tasks[task.ID] = task
@@ -224,37 +468,91 @@ func (b BoardDeps) GetBoard() (*models.Board, error) {
 			board.Columns[task.State] = column
 		}
 	}
-
-	// Get comments for each task
-	for taskID := range board.Tasks {
-		commentRows, err := b.db.Query(`
-			SELECT c.id, c.content, u.username as author, c.created_at
-			FROM comments c
-			JOIN users u ON c.author = u.id
-			WHERE c.task_id = $1
-			ORDER BY c.created_at ASC
-		`, taskID)
-		if err != nil {
-			continue // Skip comments if there's an error
+	if err = taskRows.Err(); err != nil {
+		return nil, err
+	}
+	if query != nil && query.Ordered() {
+		for _, column := range board.Columns {
+			sort.SliceStable(column.TaskIDs, func(i, j int) bool {
+				a, b := board.Tasks[column.TaskIDs[i]], board.Tasks[column.TaskIDs[j]]
+				return query.Less(&a, &b)
+			})
 		}
+	}
+
+	switch filter.Comments {
+	case models.BoardCommentsNone:
+	case models.BoardCommentsCount:
+		err = b.countBoardComments(ctx, board)
+	default:
+		err = b.loadBoardComments(ctx, board)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return board, nil
+}
+
+// loadBoardComments adds the comments of the board's tasks, oldest first, in
+// one query
+func (b BoardDeps) loadBoardComments(ctx context.Context, board *models.Board) error {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT c.task_id, c.id, c.content, COALESCE(u.username, $2) as author, c.created_at
+		FROM comments c
+		JOIN tasks t ON t.id = c.task_id
+		LEFT JOIN users u ON c.author = u.id
+		WHERE t.board_id = $1
+		ORDER BY c.created_at ASC
+	`, board.ID, models.DeletedUserName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-		var comments []models.Comment
-		for commentRows.Next() {
-			var comment models.Comment
-			err := commentRows.Scan(&comment.ID, &comment.Content, &comment.Author, &comment.CreatedAt)
-			if err != nil {
-				continue
-			}
-			comments = append(comments, comment)
+	for rows.Next() {
+		var taskID string
+		var comment models.Comment
+		if err := rows.Scan(&taskID, &comment.ID, &comment.Content, &comment.Author, &comment.CreatedAt); err != nil {
+			return err
+		}
+		// Tasks left out by the filter
+		task, ok := board.Tasks[taskID]
+		if !ok {
+			continue
 		}
-		commentRows.Close()
+		task.Comments = append(task.Comments, comment)
+		task.CommentCount++
+		board.Tasks[taskID] = task
+	}
+	return rows.Err()
+}
 
-		if len(comments) > 0 {
-			task := board.Tasks[taskID]
-			task.Comments = comments
+// countBoardComments sets the comment count of the board's tasks without
+// loading the comments
+func (b BoardDeps) countBoardComments(ctx context.Context, board *models.Board) error {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT c.task_id, COUNT(*)
+		FROM comments c
+		JOIN tasks t ON t.id = c.task_id
+		WHERE t.board_id = $1
+		GROUP BY c.task_id
+	`, board.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID string
+		var count int
+		if err := rows.Scan(&taskID, &count); err != nil {
+			return err
+		}
+		if task, ok := board.Tasks[taskID]; ok {
+			task.CommentCount = count
 			board.Tasks[taskID] = task
 		}
 	}
-
-	return board, nil
+	return rows.Err()
 }
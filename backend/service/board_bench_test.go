@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"belykh-ik/taskflow/models"
+)
+
+// The generated board of BenchmarkGetBoard
+const (
+	benchTasks     = 5000
+	benchComments  = 3
+	benchAssignees = 2
+	benchUsers     = 20
+)
+
+// BenchmarkGetBoard measures how long the board view takes to load for a
+// large board in every comment mode. It runs against DATABASE_URL, migrated
+// by the server or "taskflow migrate", and is skipped without it:
+//
+//	DATABASE_URL=postgres://... go test ./service -run '^$' -bench GetBoard
+func BenchmarkGetBoard(b *testing.B) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		b.Skip("DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	ctx := context.Background()
+
+	orgs := NewOrgDeps(db, NewRoleDeps(db))
+	org, err := orgs.CreateOrg(ctx, fmt.Sprintf("boardbench-%d", time.Now().UnixNano()), models.OrgSettings{})
+	if err != nil {
+		b.Fatal("creating organization: ", err)
+	}
+	b.Cleanup(func() {
+		if _, err := db.Exec("DELETE FROM organizations WHERE id = $1", org.ID); err != nil {
+			b.Error("deleting organization: ", err)
+		}
+	})
+
+	boards := NewBoardDeps(db)
+	boardID, err := boards.DefaultBoard(ctx, org.ID)
+	if err != nil {
+		b.Fatal("loading board: ", err)
+	}
+	if err = seedBenchBoard(ctx, db, org.ID, boardID); err != nil {
+		b.Fatal("seeding board: ", err)
+	}
+
+	for _, mode := range []string{models.BoardCommentsAll, models.BoardCommentsCount, models.BoardCommentsNone} {
+		b.Run("comments="+mode, func(b *testing.B) {
+			filter := models.BoardFilter{Comments: mode}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := boards.GetBoard(ctx, org.ID, boardID, filter); err != nil {
+					b.Fatal("loading board: ", err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.Elapsed().Milliseconds())/float64(b.N), "ms/board")
+			b.ReportMetric(float64(b.Elapsed().Microseconds())/float64(b.N*benchTasks), "µs/task")
+		})
+	}
+}
+
+// seedBenchBoard fills the board with tasks spread over the default columns,
+// each with comments by and assignees among a set of generated users
+func seedBenchBoard(ctx context.Context, db *sql.DB, orgID, boardID int) error {
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO users (org_id, username, email, password)
+		SELECT $1, 'Bench user ' || n, 'boardbench-' || $1 || '-' || n || '@example.com', ''
+		FROM generate_series(1, $2) n
+	`, orgID, benchUsers); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO tasks (board_id, title, description, state, priority)
+		SELECT $1, 'Task ' || n, 'Generated by BenchmarkGetBoard',
+		       (ARRAY['backlog', 'inprogress', 'aprove', 'done'])[1 + n % 4], 1 + n % 3
+		FROM generate_series(1, $2) n
+	`, boardID, benchTasks); err != nil {
+		return err
+	}
+	// Users and tasks are numbered so each task gets consecutive users
+	if _, err := db.ExecContext(ctx, `
+		WITH u AS (SELECT id, row_number() OVER (ORDER BY id) - 1 AS n FROM users WHERE org_id = $2),
+		     t AS (SELECT id, row_number() OVER (ORDER BY id) AS n FROM tasks WHERE board_id = $1)
+		INSERT INTO task_assignees (task_id, user_id)
+		SELECT t.id, u.id
+		FROM t CROSS JOIN generate_series(0, $3 - 1) k
+		JOIN u ON u.n = (t.n + k) % $4
+	`, boardID, orgID, benchAssignees, benchUsers); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		WITH u AS (SELECT id, row_number() OVER (ORDER BY id) - 1 AS n FROM users WHERE org_id = $2)
+		INSERT INTO comments (task_id, content, author)
+		SELECT t.id, 'Comment ' || k, u.id
+		FROM tasks t, generate_series(1, $3) k, u
+		WHERE t.board_id = $1 AND u.n = k % $4
+	`, boardID, orgID, benchComments, benchUsers)
+	return err
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// Kind classifies domain errors, the handlers answer each kind with its own
+// status code
+type Kind int
+
+const (
+	KindValidation Kind = iota + 1
+	KindNotFound
+	KindConflict
+	KindForbidden
+	KindUnauthorized
+	KindPrecondition
+)
+
+// Status returns the HTTP status of the kind
+func (k Kind) Status() int {
+	switch k {
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindPrecondition:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is a domain error of the service layer. Code is a stable identifier
+// for clients, Fields has a message per invalid field of validation errors
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  map[string]string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// ValidationError reports the invalid fields of a request
+func ValidationError(fields map[string]string) *Error {
+	return &Error{Kind: KindValidation, Code: "validation_failed", Message: "invalid request", Fields: fields}
+}
+
+// AsError returns the domain error in err's chain
+func AsError(err error) (*Error, bool) {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr, true
+	}
+	return nil, false
+}
+
+// notFound reports missing rows and malformed ids as the not found error
+func notFound(err error, notFoundErr *Error) error {
+	if err == sql.ErrNoRows {
+		return notFoundErr
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "22P02" {
+		return notFoundErr
+	}
+	return err
+}
+
+var (
+	ErrUserNotFound         = newError(KindNotFound, "user_not_found", "user not found")
+	ErrTaskNotFound         = newError(KindNotFound, "task_not_found", "task not found")
+	ErrNotificationNotFound = newError(KindNotFound, "notification_not_found", "notification not found")
+	ErrVersionMismatch      = newError(KindPrecondition, "version_mismatch", "modified since it was read, reload and retry")
+)
+
+// matchVersion checks the version of a locked row against the versions the
+// client read, none means any version
+func matchVersion(current int, expected []int) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	for _, version := range expected {
+		if version == current {
+			return nil
+		}
+	}
+	return ErrVersionMismatch
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"belykh-ik/taskflow/models"
+)
+
+var (
+	ErrFilterNotFound = newError(KindNotFound, "filter_not_found", "filter not found")
+	ErrFilterNotOwner = newError(KindForbidden, "filter_not_owner", "only the owner can change a filter")
+)
+
+type FilterDeps struct {
+	db *sql.DB
+}
+
+func NewFilterDeps(db *sql.DB) *FilterDeps {
+	return &FilterDeps{
+		db: db,
+	}
+}
+
+// ValidateSavedFilter checks the name and the query of a saved filter
+func ValidateSavedFilter(req *models.SavedFilterRequest) error {
+	f := fieldErrors{}
+	f.text(req.Name, "name", 1, maxTitleLength)
+	f.check(strings.TrimSpace(req.Query) != "", "query", "is required")
+	if _, err := ParseTaskQuery(req.Query); err != nil {
+		if e, ok := AsError(err); ok {
+			for field, message := range e.Fields {
+				f.check(false, field, message)
+			}
+		}
+	}
+	return f.err()
+}
+
+// GetFilters lists the filters of the user and the filters shared in the
+// organization
+func (f FilterDeps) GetFilters(ctx context.Context, orgID int, userID string) ([]models.SavedFilter, error) {
+	ctx, span := tracer.Start(ctx, "FilterDeps.GetFilters")
+	defer span.End()
+
+	return listFilters(ctx, f.db, "WHERE f.org_id = $1 AND (f.user_id = $2 OR f.shared)", orgID, userID)
+}
+
+// GetFilter returns a filter of the user or a shared one
+func (f FilterDeps) GetFilter(ctx context.Context, orgID int, userID, filterID string) (*models.SavedFilter, error) {
+	ctx, span := tracer.Start(ctx, "FilterDeps.GetFilter")
+	defer span.End()
+
+	if !isUUID(filterID) {
+		return nil, ErrFilterNotFound
+	}
+	filters, err := listFilters(ctx, f.db, "WHERE f.id = $1 AND f.org_id = $2 AND (f.user_id = $3 OR f.shared)", filterID, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) == 0 {
+		return nil, ErrFilterNotFound
+	}
+	return &filters[0], nil
+}
+
+// CreateFilter saves a filter of the user, checked with ValidateSavedFilter
+func (f FilterDeps) CreateFilter(ctx context.Context, orgID int, userID string, req models.SavedFilterRequest) (*models.SavedFilter, error) {
+	ctx, span := tracer.Start(ctx, "FilterDeps.CreateFilter")
+	defer span.End()
+
+	var filterID string
+	err := f.db.QueryRowContext(ctx, `
+		INSERT INTO saved_filters (org_id, user_id, name, query, shared) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, orgID, userID, strings.TrimSpace(req.Name), req.Query, req.Shared).Scan(&filterID)
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFilter(ctx, orgID, userID, filterID)
+}
+
+// UpdateFilter replaces a filter, only its owner can change it
+func (f FilterDeps) UpdateFilter(ctx context.Context, orgID int, userID, filterID string, req models.SavedFilterRequest) (*models.SavedFilter, error) {
+	ctx, span := tracer.Start(ctx, "FilterDeps.UpdateFilter")
+	defer span.End()
+
+	if !isUUID(filterID) {
+		return nil, ErrFilterNotFound
+	}
+	result, err := f.db.ExecContext(ctx, `
+		UPDATE saved_filters SET name = $1, query = $2, shared = $3, updated_at = NOW()
+		WHERE id = $4 AND org_id = $5 AND user_id = $6
+	`, strings.TrimSpace(req.Name), req.Query, req.Shared, filterID, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, f.ownerError(ctx, orgID, userID, filterID)
+	}
+	return f.GetFilter(ctx, orgID, userID, filterID)
+}
+
+// DeleteFilter deletes a filter, only its owner can delete it
+func (f FilterDeps) DeleteFilter(ctx context.Context, orgID int, userID, filterID string) error {
+	ctx, span := tracer.Start(ctx, "FilterDeps.DeleteFilter")
+	defer span.End()
+
+	if !isUUID(filterID) {
+		return ErrFilterNotFound
+	}
+	result, err := f.db.ExecContext(ctx, "DELETE FROM saved_filters WHERE id = $1 AND org_id = $2 AND user_id = $3", filterID, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return f.ownerError(ctx, orgID, userID, filterID)
+	}
+	return nil
+}
+
+// ownerError tells a shared filter of another user, which can't be changed,
+// from a filter the user can't see
+func (f FilterDeps) ownerError(ctx context.Context, orgID int, userID, filterID string) error {
+	if _, err := f.GetFilter(ctx, orgID, userID, filterID); err != nil {
+		return err
+	}
+	return ErrFilterNotOwner
+}
+
+// listFilters lists the saved filters matching the filter on saved_filters f
+func listFilters(ctx context.Context, q querier, filter string, args ...interface{}) ([]models.SavedFilter, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT f.id, f.name, f.query, f.shared, f.user_id, u.username, f.created_at, f.updated_at
+		FROM saved_filters f
+		JOIN users u ON u.id = f.user_id
+		`+filter+`
+		ORDER BY lower(f.name), f.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := []models.SavedFilter{}
+	for rows.Next() {
+		var saved models.SavedFilter
+		if err := rows.Scan(&saved.ID, &saved.Name, &saved.Query, &saved.Shared, &saved.OwnerID, &saved.Owner, &saved.CreatedAt, &saved.UpdatedAt); err != nil {
+			return nil, err
+		}
+		filters = append(filters, saved)
+	}
+	return filters, rows.Err()
+}
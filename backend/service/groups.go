@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrGroupNotFound     = newError(KindNotFound, "group_not_found", "group not found")
+	ErrInvalidGroup      = newError(KindValidation, "invalid_group", "group name is required")
+	ErrGroupNameInUse    = newError(KindConflict, "group_name_in_use", "group name already in use")
+	ErrGroupUserNotFound = newError(KindNotFound, "user_not_found", "user not found")
+	ErrNotGroupMember    = newError(KindNotFound, "group_member_not_found", "user is not a member of this group")
+)
+
+type GroupDeps struct {
+	db *sql.DB
+}
+
+func NewGroupDeps(db *sql.DB) *GroupDeps {
+	return &GroupDeps{
+		db: db,
+	}
+}
+
+// GetGroups lists the groups of the organization with their member count
+func (g GroupDeps) GetGroups(ctx context.Context, orgID int) ([]models.Group, error) {
+	ctx, span := tracer.Start(ctx, "GroupDeps.GetGroups")
+	defer span.End()
+
+	rows, err := g.db.QueryContext(ctx, `
+		SELECT g.id, g.name, g.description, COUNT(m.user_id), g.created_at
+		FROM groups g
+		LEFT JOIN group_members m ON m.group_id = g.id
+		WHERE g.org_id = $1
+		GROUP BY g.id
+		ORDER BY lower(g.name)
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.MemberCount, &group.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroup returns a group of the organization with its members
+func (g GroupDeps) GetGroup(ctx context.Context, orgID int, groupID string) (*models.Group, error) {
+	ctx, span := tracer.Start(ctx, "GroupDeps.GetGroup")
+	defer span.End()
+
+	var group models.Group
+	err := g.db.QueryRowContext(ctx, `
+		SELECT id, name, description, created_at FROM groups WHERE id = $1 AND org_id = $2
+	`, groupID, orgID).Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt)
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	rows, err := g.db.QueryContext(ctx, `
+		SELECT u.id, u.username, u.email, m.created_at
+		FROM group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY u.username
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	group.Members = []models.GroupMember{}
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Email, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		group.Members = append(group.Members, member)
+	}
+	group.MemberCount = len(group.Members)
+	return &group, rows.Err()
+}
+
+// Exists reports whether the group belongs to the organization
+func (g GroupDeps) Exists(ctx context.Context, orgID int, groupID string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "GroupDeps.Exists")
+	defer span.End()
+
+	var exists bool
+	err := g.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM groups WHERE id = $1 AND org_id = $2)", groupID, orgID).Scan(&exists)
+	if errors.Is(groupError(err), ErrGroupNotFound) {
+		return false, nil
+	}
+	return exists, err
+}
+
+func (g GroupDeps) CreateGroup(ctx context.Context, orgID int, name, description string) (*models.Group, error) {
+	ctx, span := tracer.Start(ctx, "GroupDeps.CreateGroup")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, ErrInvalidGroup
+	}
+
+	group := models.Group{Name: name, Description: description, Members: []models.GroupMember{}}
+	err := g.db.QueryRowContext(ctx, `
+		INSERT INTO groups (org_id, name, description) VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, orgID, name, description).Scan(&group.ID, &group.CreatedAt)
+	if err != nil {
+		return nil, groupError(err)
+	}
+	return &group, nil
+}
+
+// UpdateGroup renames the group when name is set and replaces its description
+func (g GroupDeps) UpdateGroup(ctx context.Context, orgID int, groupID, name, description string) (*models.Group, error) {
+	ctx, span := tracer.Start(ctx, "GroupDeps.UpdateGroup")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if len(name) > 255 {
+		return nil, ErrInvalidGroup
+	}
+
+	result, err := g.db.ExecContext(ctx, `
+		UPDATE groups
+		SET name = COALESCE(NULLIF($1, ''), name), description = $2, updated_at = NOW()
+		WHERE id = $3 AND org_id = $4
+	`, name, description, groupID, orgID)
+	if err != nil {
+		return nil, groupError(err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, ErrGroupNotFound
+	}
+	return g.GetGroup(ctx, orgID, groupID)
+}
+
+// DeleteGroup deletes the group, its tasks keep their individual assignees
+func (g GroupDeps) DeleteGroup(ctx context.Context, orgID int, groupID string) error {
+	ctx, span := tracer.Start(ctx, "GroupDeps.DeleteGroup")
+	defer span.End()
+
+	result, err := g.db.ExecContext(ctx, "DELETE FROM groups WHERE id = $1 AND org_id = $2", groupID, orgID)
+	if err != nil {
+		return groupError(err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// AddMember adds a user of the organization to the group
+func (g GroupDeps) AddMember(ctx context.Context, orgID int, groupID, userID string) error {
+	ctx, span := tracer.Start(ctx, "GroupDeps.AddMember")
+	defer span.End()
+
+	exists, err := g.Exists(ctx, orgID, groupID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrGroupNotFound
+	}
+
+	result, err := g.db.ExecContext(ctx, `
+		INSERT INTO group_members (group_id, user_id)
+		SELECT $1, id FROM users WHERE id = $2 AND org_id = $3
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`, groupID, userID, orgID)
+	if err != nil {
+		if errors.Is(groupError(err), ErrGroupNotFound) {
+			return ErrGroupUserNotFound
+		}
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		// Nothing inserted, either the user is already a member or it isn't
+		// part of the organization
+		var isUser bool
+		if err = g.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND org_id = $2)", userID, orgID).Scan(&isUser); err != nil {
+			return err
+		}
+		if !isUser {
+			return ErrGroupUserNotFound
+		}
+	}
+	return nil
+}
+
+// RemoveMember takes a user out of the group
+func (g GroupDeps) RemoveMember(ctx context.Context, orgID int, groupID, userID string) error {
+	ctx, span := tracer.Start(ctx, "GroupDeps.RemoveMember")
+	defer span.End()
+
+	exists, err := g.Exists(ctx, orgID, groupID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrGroupNotFound
+	}
+
+	result, err := g.db.ExecContext(ctx, "DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID)
+	if err != nil {
+		if errors.Is(groupError(err), ErrGroupNotFound) {
+			return ErrNotGroupMember
+		}
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrNotGroupMember
+	}
+	return nil
+}
+
+// groupError maps missing rows and malformed IDs to ErrGroupNotFound and
+// duplicate names to ErrGroupNameInUse
+func groupError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "22P02":
+			return ErrGroupNotFound
+		case "23505":
+			return ErrGroupNameInUse
+		}
+	}
+	return err
+}
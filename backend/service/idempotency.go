@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"belykh-ik/taskflow/models"
+)
+
+// staleReservation is how long a key stays claimed by a request that never
+// stored its response, e.g. because the server stopped. It is well past the
+// request timeouts
+const staleReservation = 5 * time.Minute
+
+// IdempotencyDeps stores the responses of requests sent with an
+// Idempotency-Key, keyed by user and key
+type IdempotencyDeps struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+func NewIdempotencyDeps(db *sql.DB, ttl time.Duration) *IdempotencyDeps {
+	return &IdempotencyDeps{
+		db:  db,
+		ttl: ttl,
+	}
+}
+
+// Reserve claims the key for a request. It returns nil when the request is
+// the first with the key and should run, otherwise the stored response of the
+// earlier request. Expired keys of the user and stale claims are dropped first
+func (i IdempotencyDeps) Reserve(ctx context.Context, userID, key, fingerprint string) (*models.IdempotentResponse, error) {
+	ctx, span := tracer.Start(ctx, "IdempotencyDeps.Reserve")
+	defer span.End()
+
+	now := time.Now()
+	if _, err := i.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND (created_at < $2 OR (status = 0 AND created_at < $3))
+	`, userID, now.Add(-i.ttl), now.Add(-staleReservation)); err != nil {
+		return nil, err
+	}
+
+	result, err := i.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING
+	`, userID, key, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	var stored models.IdempotentResponse
+	var headers []byte
+	err = i.db.QueryRowContext(ctx, `
+		SELECT fingerprint, status, headers, COALESCE(body, '') FROM idempotency_keys WHERE user_id = $1 AND key = $2
+	`, userID, key).Scan(&stored.Fingerprint, &stored.Status, &headers, &stored.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(headers, &stored.Headers); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Save stores the response of the request holding the key
+func (i IdempotencyDeps) Save(ctx context.Context, userID, key string, response models.IdempotentResponse) error {
+	ctx, span := tracer.Start(ctx, "IdempotencyDeps.Save")
+	defer span.End()
+
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return err
+	}
+	_, err = i.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status = $3, headers = $4, body = $5
+		WHERE user_id = $1 AND key = $2
+	`, userID, key, response.Status, string(headers), response.Body)
+	return err
+}
+
+// Release frees the key of a request that failed, so a retry runs again
+func (i IdempotencyDeps) Release(ctx context.Context, userID, key string) error {
+	ctx, span := tracer.Start(ctx, "IdempotencyDeps.Release")
+	defer span.End()
+
+	_, err := i.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status = 0", userID, key)
+	return err
+}
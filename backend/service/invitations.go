@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/mail"
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultInvitationLifetimeDays = 7
+	maxInvitationLifetimeDays     = 30
+)
+
+var (
+	ErrInvalidInvitation   = newError(KindValidation, "invalid_invitation", "invalid or expired invitation")
+	ErrAlreadyBootstrapped = newError(KindConflict, "already_bootstrapped", "an administrator already exists")
+)
+
+type InvitationDeps struct {
+	db      *sql.DB
+	mailer  mail.Sender
+	appURL  string
+	members *MemberDeps
+}
+
+func NewInvitationDeps(db *sql.DB, mailer mail.Sender, appURL string, members *MemberDeps) *InvitationDeps {
+	return &InvitationDeps{
+		db:      db,
+		mailer:  mailer,
+		appURL:  strings.TrimSuffix(appURL, "/"),
+		members: members,
+	}
+}
+
+// CreateInvitation emails a link creating an account for the address in the
+// organization. A pending invitation for the same address is replaced
+func (i InvitationDeps) CreateInvitation(ctx context.Context, orgID int, inviterID string, req models.CreateInvitationRequest) (*models.CreateInvitationResponse, error) {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.CreateInvitation")
+	defer span.End()
+
+	email := strings.TrimSpace(req.Email)
+	if !validEmail(email) {
+		return nil, ErrInvalidEmail
+	}
+
+	var count int
+	if err := i.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE lower(email) = lower($1)", email).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrEmailInUse
+	}
+
+	days := req.ExpiresInDays
+	if days <= 0 {
+		days = defaultInvitationLifetimeDays
+	}
+	if days > maxInvitationLifetimeDays {
+		days = maxInvitationLifetimeDays
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(raw)
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM user_invitations WHERE org_id = $1 AND lower(email) = lower($2) AND accepted_at IS NULL
+	`, orgID, email); err != nil {
+		return nil, err
+	}
+
+	invitation := models.Invitation{OrgID: orgID, Email: email, Role: req.Role}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO user_invitations (org_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, expires_at, created_at,
+		          (SELECT name FROM organizations WHERE id = $1),
+		          COALESCE((SELECT username FROM users WHERE id = $5), '')
+	`, orgID, email, req.Role, hashToken(token), inviterID, time.Now().AddDate(0, 0, days)).
+		Scan(&invitation.ID, &invitation.ExpiresAt, &invitation.CreatedAt, &invitation.OrgName, &invitation.InvitedBy)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	link := fmt.Sprintf("%s/accept-invitation?token=%s", i.appURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Вас пригласили в SmartBoard (%s). Чтобы создать учетную запись, перейдите по ссылке:\n\n%s\n\nСсылка действительна до %s.",
+		invitation.OrgName, link, invitation.ExpiresAt.Format("02.01.2006 15:04"))
+	if err := i.mailer.Send(email, "Приглашение в SmartBoard", body); err != nil {
+		logging.FromContext(ctx).Error("Error sending invitation email", "err", err)
+	}
+
+	return &models.CreateInvitationResponse{Link: link, Invitation: invitation}, nil
+}
+
+// GetInvitations lists the pending invitations of the organization
+func (i InvitationDeps) GetInvitations(ctx context.Context, orgID int) ([]models.Invitation, error) {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.GetInvitations")
+	defer span.End()
+
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT inv.id, inv.org_id, inv.email, inv.role, COALESCE(u.username, ''), inv.expires_at, inv.created_at
+		FROM user_invitations inv
+		LEFT JOIN users u ON u.id = inv.invited_by
+		WHERE inv.org_id = $1 AND inv.accepted_at IS NULL AND inv.expires_at > NOW()
+		ORDER BY inv.created_at DESC
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		var inv models.Invitation
+		if err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation deletes a pending invitation of the organization
+func (i InvitationDeps) RevokeInvitation(ctx context.Context, orgID int, invitationID string) error {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.RevokeInvitation")
+	defer span.End()
+
+	result, err := i.db.ExecContext(ctx, `
+		DELETE FROM user_invitations WHERE id = $1 AND org_id = $2 AND accepted_at IS NULL
+	`, invitationID, orgID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "22P02" {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// GetInvitation returns the pending invitation of a link, so the signup form
+// can show the email and organization
+func (i InvitationDeps) GetInvitation(ctx context.Context, token string) (*models.Invitation, error) {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.GetInvitation")
+	defer span.End()
+
+	var inv models.Invitation
+	err := i.db.QueryRowContext(ctx, `
+		SELECT inv.id, inv.org_id, o.name, inv.email, inv.role, inv.expires_at, inv.created_at
+		FROM user_invitations inv
+		JOIN organizations o ON o.id = inv.org_id
+		WHERE inv.token_hash = $1 AND inv.accepted_at IS NULL AND inv.expires_at > NOW()
+	`, hashToken(token)).Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.Email, &inv.Role, &inv.ExpiresAt, &inv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// AcceptInvitation creates the invited account. The link was sent to the
+// email, so the address counts as verified
+func (i InvitationDeps) AcceptInvitation(ctx context.Context, token, username, password string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.AcceptInvitation")
+	defer span.End()
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var orgID int
+	var email, role string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE user_invitations SET accepted_at = NOW()
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW()
+		RETURNING org_id, email, role
+	`, hashToken(token)).Scan(&orgID, &email, &role)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (org_id, username, email, password, role, email_verified, created_at)
+		VALUES ($1, $2, $3, $4, $5, true, $6)
+		RETURNING id, username, email, role, org_id, email_verified, deactivated_at IS NULL, created_at
+	`, orgID, username, email, password, role, time.Now()).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.EmailVerified, &user.Active, &user.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrEmailInUse
+		}
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err = i.members.JoinDefaultBoard(ctx, user.ID); err != nil {
+		logging.FromContext(ctx).Error("Error adding user to the default board", "user_id", user.ID, "err", err)
+	}
+	return &user, nil
+}
+
+// NeedsBootstrap reports whether there is no super-admin yet
+func (i InvitationDeps) NeedsBootstrap(ctx context.Context) (bool, error) {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.NeedsBootstrap")
+	defer span.End()
+
+	var exists bool
+	err := i.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE is_super_admin)").Scan(&exists)
+	return !exists, err
+}
+
+// BootstrapAdmin creates the first super-admin as owner of the default board.
+// Concurrent calls are serialized and only the first one succeeds
+func (i InvitationDeps) BootstrapAdmin(ctx context.Context, username, email, password string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "InvitationDeps.BootstrapAdmin")
+	defer span.End()
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return nil, err
+	}
+	var exists bool
+	if err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE is_super_admin)").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAlreadyBootstrapped
+	}
+
+	var user models.User
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (org_id, username, email, password, role, email_verified, is_super_admin, created_at)
+		VALUES ($1, $2, $3, $4, $5, true, true, $6)
+		RETURNING id, username, email, role, org_id, is_super_admin, email_verified, deactivated_at IS NULL, created_at
+	`, models.DefaultOrgID, username, email, password, models.RoleAdmin, time.Now()).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.EmailVerified, &user.Active, &user.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrEmailInUse
+		}
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO board_members (board_id, user_id, role)
+		SELECT id, $1, $2 FROM board_config WHERE org_id = $3 AND is_default
+		ON CONFLICT (board_id, user_id) DO NOTHING
+	`, user.ID, models.BoardRoleOwner, models.DefaultOrgID); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/metrics"
+	"belykh-ik/taskflow/models"
+)
+
+var (
+	ErrInvalidBoardRole   = newError(KindValidation, "invalid_board_role", "invalid board role")
+	ErrMemberNotFound     = newError(KindNotFound, "board_member_not_found", "user is not a member of this board")
+	ErrLastOwner          = newError(KindConflict, "last_board_owner", "a board needs at least one owner")
+	ErrAlreadyMember      = newError(KindConflict, "already_board_member", "user is already a member of this board")
+	ErrInvitationNotFound = newError(KindNotFound, "invitation_not_found", "invitation not found")
+	ErrInvalidEmail       = newError(KindValidation, "invalid_email", "invalid email")
+)
+
+type MemberDeps struct {
+	db          *sql.DB
+	defaultRole string
+}
+
+func NewMemberDeps(db *sql.DB, defaultRole string) *MemberDeps {
+	return &MemberDeps{
+		db:          db,
+		defaultRole: defaultRole,
+	}
+}
+
+// BoardRole returns the role of the user on a board of the organization, or
+// an empty string when the user isn't a member
+func (m MemberDeps) BoardRole(ctx context.Context, orgID, boardID int, userID string) (string, error) {
+	ctx, span := tracer.Start(ctx, "MemberDeps.BoardRole")
+	defer span.End()
+
+	var role string
+	err := m.db.QueryRowContext(ctx, `
+		SELECT COALESCE(m.role, '')
+		FROM board_config b
+		LEFT JOIN board_members m ON m.board_id = b.id AND m.user_id = $2
+		WHERE b.id = $1 AND b.org_id = $3
+	`, boardID, userID, orgID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrBoardNotFound
+	}
+	return role, err
+}
+
+// JoinDefaultBoard gives a new user the default board role of their
+// organization on its default board, falling back to the server setting
+func (m MemberDeps) JoinDefaultBoard(ctx context.Context, userID string) error {
+	ctx, span := tracer.Start(ctx, "MemberDeps.JoinDefaultBoard")
+	defer span.End()
+
+	var boardID int
+	var orgRole string
+	err := m.db.QueryRowContext(ctx, `
+		SELECT b.id, COALESCE(o.settings->>'defaultBoardRole', '')
+		FROM users u
+		JOIN organizations o ON o.id = u.org_id
+		JOIN board_config b ON b.org_id = u.org_id AND b.is_default
+		WHERE u.id = $1
+	`, userID).Scan(&boardID, &orgRole)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	role := m.defaultRole
+	if orgRole != "" {
+		role = orgRole
+	}
+	if !models.IsBoardRole(role) {
+		return nil
+	}
+	_, err = m.db.ExecContext(ctx, `
+		INSERT INTO board_members (board_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (board_id, user_id) DO NOTHING
+	`, boardID, userID, role)
+	return err
+}
+
+func (m MemberDeps) GetMembers(ctx context.Context, orgID, boardID int) ([]models.BoardMember, error) {
+	ctx, span := tracer.Start(ctx, "MemberDeps.GetMembers")
+	defer span.End()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT u.id, u.username, u.email, m.role, m.created_at
+		FROM board_members m
+		JOIN users u ON u.id = m.user_id
+		JOIN board_config b ON b.id = m.board_id
+		WHERE m.board_id = $1 AND b.org_id = $2
+		ORDER BY u.username
+	`, boardID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.BoardMember{}
+	for rows.Next() {
+		var member models.BoardMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Email, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetMemberRole changes the role of a member, keeping at least one owner
+func (m MemberDeps) SetMemberRole(ctx context.Context, orgID, boardID int, userID, role string) error {
+	ctx, span := tracer.Start(ctx, "MemberDeps.SetMemberRole")
+	defer span.End()
+
+	if !models.IsBoardRole(role) {
+		return ErrInvalidBoardRole
+	}
+	return m.changeMember(ctx, orgID, boardID, userID, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE board_members SET role = $1 WHERE board_id = $2 AND user_id = $3", role, boardID, userID)
+		return err
+	}, role != models.BoardRoleOwner)
+}
+
+// RemoveMember takes the board away from a user, keeping at least one owner
+func (m MemberDeps) RemoveMember(ctx context.Context, orgID, boardID int, userID string) error {
+	ctx, span := tracer.Start(ctx, "MemberDeps.RemoveMember")
+	defer span.End()
+
+	return m.changeMember(ctx, orgID, boardID, userID, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM board_members WHERE board_id = $1 AND user_id = $2", boardID, userID)
+		return err
+	}, true)
+}
+
+// changeMember runs the change with the board owners locked, refusing to
+// remove the last owner
+func (m MemberDeps) changeMember(ctx context.Context, orgID, boardID int, userID string, change func(tx *sql.Tx) error, losesOwner bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = lockBoard(ctx, tx, orgID, boardID); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT user_id, role FROM board_members WHERE board_id = $1 FOR UPDATE", boardID)
+	if err != nil {
+		return err
+	}
+	owners := 0
+	current := ""
+	for rows.Next() {
+		var id, role string
+		if err := rows.Scan(&id, &role); err != nil {
+			rows.Close()
+			return err
+		}
+		if role == models.BoardRoleOwner {
+			owners++
+		}
+		if id == userID {
+			current = role
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if current == "" {
+		return ErrMemberNotFound
+	}
+	if losesOwner && current == models.BoardRoleOwner && owners <= 1 {
+		return ErrLastOwner
+	}
+
+	if err = change(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Invite invites an email address to the board. The invitation shows up for
+// the account of the organization with that verified email, now or after it
+// registers
+func (m MemberDeps) Invite(ctx context.Context, orgID, boardID int, inviterID, email, role string) (*models.BoardInvitation, error) {
+	ctx, span := tracer.Start(ctx, "MemberDeps.Invite")
+	defer span.End()
+
+	email = strings.TrimSpace(email)
+	if !validEmail(email) {
+		return nil, ErrInvalidEmail
+	}
+	if !models.IsBoardRole(role) {
+		return nil, ErrInvalidBoardRole
+	}
+
+	var exists bool
+	err := m.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM board_config WHERE id = $1 AND org_id = $2)", boardID, orgID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrBoardNotFound
+	}
+
+	var member bool
+	err = m.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM board_members bm JOIN users u ON u.id = bm.user_id
+			WHERE bm.board_id = $1 AND lower(u.email) = lower($2)
+		)
+	`, boardID, email).Scan(&member)
+	if err != nil {
+		return nil, err
+	}
+	if member {
+		return nil, ErrAlreadyMember
+	}
+
+	invitation := models.BoardInvitation{BoardID: boardID, Email: email, Role: role}
+	err = m.db.QueryRowContext(ctx, `
+		INSERT INTO board_invitations (board_id, email, role, invited_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (board_id, lower(email)) DO UPDATE
+		SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = NOW()
+		RETURNING id, created_at, (SELECT name FROM board_config WHERE id = $1)
+	`, boardID, email, role, inviterID).Scan(&invitation.ID, &invitation.CreatedAt, &invitation.BoardName)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.db.QueryRowContext(ctx, "SELECT username FROM users WHERE id = $1", inviterID).Scan(&invitation.InvitedBy); err != nil {
+		logging.FromContext(ctx).Error("Error loading inviter", "err", err)
+	}
+
+	// Let an existing account know about the invitation
+	result, err := m.db.ExecContext(ctx, `
+		INSERT INTO notifications (user_id, message, read, created_at)
+		SELECT id, $2, false, $3 FROM users WHERE lower(email) = lower($1) AND org_id = $4
+	`, email, fmt.Sprintf("Вас пригласили на доску '%s'", invitation.BoardName), time.Now(), orgID)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error creating notification", "err", err)
+	} else if n, err := result.RowsAffected(); err == nil {
+		metrics.NotificationsSent.Add(float64(n))
+	}
+	return &invitation, nil
+}
+
+// GetInvitations lists the pending invitations of a board
+func (m MemberDeps) GetInvitations(ctx context.Context, orgID, boardID int) ([]models.BoardInvitation, error) {
+	ctx, span := tracer.Start(ctx, "MemberDeps.GetInvitations")
+	defer span.End()
+
+	return m.queryInvitations(ctx, "WHERE i.board_id = $1 AND b.org_id = $2", boardID, orgID)
+}
+
+// GetUserInvitations lists the pending invitations addressed to the user
+func (m MemberDeps) GetUserInvitations(ctx context.Context, userID string) ([]models.BoardInvitation, error) {
+	ctx, span := tracer.Start(ctx, "MemberDeps.GetUserInvitations")
+	defer span.End()
+
+	return m.queryInvitations(ctx, `
+		JOIN users u ON lower(u.email) = lower(i.email) AND u.email_verified AND u.org_id = b.org_id
+		WHERE u.id = $1
+	`, userID)
+}
+
+func (m MemberDeps) queryInvitations(ctx context.Context, filter string, args ...interface{}) ([]models.BoardInvitation, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT i.id, i.board_id, b.name, i.email, i.role, COALESCE(inviter.username, ''), i.created_at
+		FROM board_invitations i
+		JOIN board_config b ON b.id = i.board_id
+		LEFT JOIN users inviter ON inviter.id = i.invited_by
+		`+filter+`
+		ORDER BY i.created_at DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.BoardInvitation{}
+	for rows.Next() {
+		var inv models.BoardInvitation
+		if err := rows.Scan(&inv.ID, &inv.BoardID, &inv.BoardName, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation deletes a pending invitation of the board
+func (m MemberDeps) RevokeInvitation(ctx context.Context, orgID, boardID int, invitationID string) error {
+	ctx, span := tracer.Start(ctx, "MemberDeps.RevokeInvitation")
+	defer span.End()
+
+	if !isUUID(invitationID) {
+		return ErrInvitationNotFound
+	}
+	result, err := m.db.ExecContext(ctx, `
+		DELETE FROM board_invitations i
+		USING board_config b
+		WHERE i.id = $1 AND i.board_id = $2 AND b.id = i.board_id AND b.org_id = $3
+	`, invitationID, boardID, orgID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation makes the user a member of the board it was invited to
+func (m MemberDeps) AcceptInvitation(ctx context.Context, userID, invitationID string) (*models.BoardSummary, error) {
+	ctx, span := tracer.Start(ctx, "MemberDeps.AcceptInvitation")
+	defer span.End()
+
+	if !isUUID(invitationID) {
+		return nil, ErrInvitationNotFound
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var board models.BoardSummary
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM board_invitations i
+		USING users u, board_config b
+		WHERE i.id = $1 AND u.id = $2 AND u.email_verified
+		  AND lower(u.email) = lower(i.email) AND b.id = i.board_id AND b.org_id = u.org_id
+		RETURNING i.board_id, b.name, i.role, b.created_at
+	`, invitationID, userID).Scan(&board.ID, &board.Name, &board.Role, &board.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO board_members (board_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (board_id, user_id) DO NOTHING
+	`, board.ID, userID, board.Role); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &board, nil
+}
+
+// DeclineInvitation deletes an invitation addressed to the user
+func (m MemberDeps) DeclineInvitation(ctx context.Context, userID, invitationID string) error {
+	ctx, span := tracer.Start(ctx, "MemberDeps.DeclineInvitation")
+	defer span.End()
+
+	if !isUUID(invitationID) {
+		return ErrInvitationNotFound
+	}
+	result, err := m.db.ExecContext(ctx, `
+		DELETE FROM board_invitations i
+		USING users u, board_config b
+		WHERE i.id = $1 AND u.id = $2 AND u.email_verified AND lower(u.email) = lower(i.email)
+		  AND b.id = i.board_id AND b.org_id = u.org_id
+	`, invitationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 
 	"belykh-ik/taskflow/models"
@@ -16,8 +17,11 @@ func NewNotificationDeps(db *sql.DB) *NotificationsDeps {
 	}
 }
 
-func (n NotificationsDeps) GetNotification(userID string) ([]models.Notification, error) {
-	rows, err := n.db.Query(`
+func (n NotificationsDeps) GetNotification(ctx context.Context, userID string) ([]models.Notification, error) {
+	ctx, span := tracer.Start(ctx, "NotificationsDeps.GetNotification")
+	defer span.End()
+
+	rows, err := n.db.QueryContext(ctx, `
 		SELECT id, user_id, message, read, created_at
 		FROM notifications
 		WHERE user_id = $1
@@ -40,20 +44,26 @@ func (n NotificationsDeps) GetNotification(userID string) ([]models.Notification
 	return notifications, nil
 }
 
-func (n NotificationsDeps) MarkNotificationRead(userID string, notificationID string) error {
-	result, err := n.db.Exec(`
+func (n NotificationsDeps) MarkNotificationRead(ctx context.Context, userID string, notificationID string) error {
+	ctx, span := tracer.Start(ctx, "NotificationsDeps.MarkNotificationRead")
+	defer span.End()
+
+	result, err := n.db.ExecContext(ctx, `
 	UPDATE notifications
 	SET read = true
 	WHERE id = $1 AND user_id = $2
 `, notificationID, userID)
 
 	if err != nil {
-		return err
+		return notFound(err, ErrNotificationNotFound)
 	}
 
 	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
+	if err != nil {
 		return err
 	}
+	if rowsAffected == 0 {
+		return ErrNotificationNotFound
+	}
 	return nil
 }
@@ -0,0 +1,441 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrOIDCDisabled      = newError(KindNotFound, "sso_disabled", "single sign-on is not configured")
+	ErrOIDCInvalidToken  = newError(KindUnauthorized, "invalid_id_token", "invalid ID token")
+	ErrOIDCEmailConflict = newError(KindConflict, "sso_email_conflict", "an account with this email already exists and the provider did not verify the email")
+	ErrUserDeactivated   = newError(KindForbidden, "user_deactivated", "account is deactivated")
+)
+
+// OIDCFlow holds the per-login secrets that have to survive the redirect
+// to the identity provider and back
+type OIDCFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// OIDCIdentity is the subset of ID token claims used for provisioning
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Role          string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type OIDCDeps struct {
+	db      *sql.DB
+	conf    *models.OIDCConfig
+	members *MemberDeps
+	client  *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+func NewOIDCDeps(db *sql.DB, conf *models.OIDCConfig, members *MemberDeps) *OIDCDeps {
+	return &OIDCDeps{
+		db:      db,
+		conf:    conf,
+		members: members,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (o *OIDCDeps) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (o *OIDCDeps) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.conf.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != o.conf.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", o.conf.Issuer, d.Issuer)
+	}
+	o.discovery = &d
+	return o.discovery, nil
+}
+
+// getKey returns the provider signing key, refetching the key set once when
+// the key ID is unknown to pick up key rotation
+func (o *OIDCDeps) getKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	o.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	d, err := o.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// BeginLogin returns the provider authorization URL and the flow secrets
+// the caller has to keep until the callback
+func (o *OIDCDeps) BeginLogin(ctx context.Context) (string, *OIDCFlow, error) {
+	ctx, span := tracer.Start(ctx, "OIDCDeps.BeginLogin")
+	defer span.End()
+
+	if !o.conf.Enabled() {
+		return "", nil, ErrOIDCDisabled
+	}
+	d, err := o.getDiscovery(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	flow := &OIDCFlow{}
+	if flow.State, err = randomString(16); err != nil {
+		return "", nil, err
+	}
+	if flow.Nonce, err = randomString(16); err != nil {
+		return "", nil, err
+	}
+	if flow.Verifier, err = randomString(32); err != nil {
+		return "", nil, err
+	}
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", o.conf.ClientID)
+	params.Set("redirect_uri", o.conf.RedirectURL)
+	params.Set("scope", "openid email profile")
+	params.Set("state", flow.State)
+	params.Set("nonce", flow.Nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), flow, nil
+}
+
+// CompleteLogin exchanges the authorization code, verifies the ID token and
+// returns the provisioned user
+func (o *OIDCDeps) CompleteLogin(ctx context.Context, code string, flow *OIDCFlow) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "OIDCDeps.CompleteLogin")
+	defer span.End()
+
+	if !o.conf.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	rawIDToken, err := o.exchange(ctx, code, flow.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := o.verifyIDToken(ctx, rawIDToken, flow.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return o.provisionUser(ctx, identity)
+}
+
+func (o *OIDCDeps) exchange(ctx context.Context, code, verifier string) (string, error) {
+	d, err := o.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.conf.RedirectURL)
+	form.Set("client_id", o.conf.ClientID)
+	form.Set("code_verifier", verifier)
+	if o.conf.ClientSecret != "" {
+		form.Set("client_secret", o.conf.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token exchange failed: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token exchange failed: no id_token in response")
+	}
+	return body.IDToken, nil
+}
+
+func (o *OIDCDeps) verifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return o.getKey(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidToken, err)
+	}
+
+	if !claims.VerifyIssuer(o.conf.Issuer, true) {
+		return nil, fmt.Errorf("%w: issuer mismatch", ErrOIDCInvalidToken)
+	}
+	if !claims.VerifyAudience(o.conf.ClientID, true) {
+		return nil, fmt.Errorf("%w: audience mismatch", ErrOIDCInvalidToken)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: token expired", ErrOIDCInvalidToken)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidToken)
+	}
+
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if identity.Subject == "" || identity.Email == "" {
+		return nil, fmt.Errorf("%w: sub and email claims are required", ErrOIDCInvalidToken)
+	}
+
+	for _, name := range []string{"preferred_username", "name"} {
+		if v, _ := claims[name].(string); v != "" {
+			identity.Username = v
+			break
+		}
+	}
+	if identity.Username == "" {
+		identity.Username = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	identity.Role = o.mapRole(claims[o.conf.RoleClaim])
+	return identity, nil
+}
+
+// mapRole returns the role of the first mapping matching the claim value,
+// the claim may be a single string or a list of strings. Without a match it
+// returns "" so existing users keep their role and new users get the default
+func (o *OIDCDeps) mapRole(claim interface{}) string {
+	if o.conf.RoleClaim == "" {
+		return ""
+	}
+
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	for _, mapping := range o.conf.RoleMapping {
+		for _, value := range values {
+			if value == mapping.Value {
+				return mapping.Role
+			}
+		}
+	}
+	return ""
+}
+
+// provisionUser finds the user linked to the identity, links an existing
+// account with the same verified email, or creates a new account
+func (o *OIDCDeps) provisionUser(ctx context.Context, identity *OIDCIdentity) (*models.User, error) {
+	var user models.User
+	err := o.db.QueryRowContext(ctx, `
+		SELECT id, username, email, role, org_id, is_super_admin, email_verified, deactivated_at IS NULL, created_at FROM users WHERE oidc_subject = $1
+	`, identity.Subject).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.EmailVerified, &user.Active, &user.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if err == sql.ErrNoRows {
+		err = o.db.QueryRowContext(ctx, `
+			SELECT id, username, email, role, org_id, is_super_admin, deactivated_at IS NULL, created_at FROM users WHERE lower(email) = lower($1)
+		`, identity.Email).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.Active, &user.CreatedAt)
+		switch {
+		case err == sql.ErrNoRows:
+			return o.createUser(ctx, identity)
+		case err != nil:
+			return nil, err
+		case !identity.EmailVerified:
+			return nil, ErrOIDCEmailConflict
+		case !user.Active:
+			return nil, ErrUserDeactivated
+		}
+
+		// Link the existing account
+		if _, err = o.db.ExecContext(ctx, "UPDATE users SET oidc_subject = $1, email_verified = true WHERE id = $2", identity.Subject, user.ID); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+
+	if !user.Active {
+		return nil, ErrUserDeactivated
+	}
+
+	// The provider is the source of truth for the role when a role claim is configured
+	if identity.Role != "" && identity.Role != user.Role {
+		if _, err = o.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", identity.Role, user.ID); err != nil {
+			return nil, err
+		}
+		user.Role = identity.Role
+	}
+	user.OIDCSubject = identity.Subject
+	return &user, nil
+}
+
+func (o *OIDCDeps) createUser(ctx context.Context, identity *OIDCIdentity) (*models.User, error) {
+	role := identity.Role
+	if role == "" {
+		role = o.conf.DefaultRole
+	}
+	if role == "" {
+		var err error
+		if role, err = orgDefaultRole(ctx, o.db, models.DefaultOrgID); err != nil {
+			return nil, err
+		}
+	}
+
+	// SSO accounts get an unguessable password so password login can't be used on them
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err := o.db.QueryRowContext(ctx, `
+		INSERT INTO users (username, email, password, role, email_verified, oidc_subject, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, username, email, role, org_id, email_verified, deactivated_at IS NULL, created_at
+	`, identity.Username, identity.Email, hex.EncodeToString(raw), role, identity.EmailVerified, identity.Subject, time.Now()).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.EmailVerified, &user.Active, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err = o.members.JoinDefaultBoard(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	user.OIDCSubject = identity.Subject
+	return &user, nil
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrOrgNotFound     = newError(KindNotFound, "org_not_found", "organization not found")
+	ErrInvalidOrg      = newError(KindValidation, "invalid_org", "organization name is required")
+	ErrOrgNameInUse    = newError(KindConflict, "org_name_in_use", "organization name already in use")
+	ErrInvalidSettings = newError(KindValidation, "invalid_org_settings", "invalid organization settings")
+)
+
+type OrgDeps struct {
+	db    *sql.DB
+	roles *RoleDeps
+}
+
+func NewOrgDeps(db *sql.DB, roles *RoleDeps) *OrgDeps {
+	return &OrgDeps{
+		db:    db,
+		roles: roles,
+	}
+}
+
+func (o OrgDeps) GetOrgs(ctx context.Context) ([]models.Organization, error) {
+	ctx, span := tracer.Start(ctx, "OrgDeps.GetOrgs")
+	defer span.End()
+
+	rows, err := o.db.QueryContext(ctx, "SELECT id, name, settings, created_at FROM organizations ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []models.Organization{}
+	for rows.Next() {
+		org, err := scanOrg(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, *org)
+	}
+	return orgs, rows.Err()
+}
+
+func (o OrgDeps) GetOrg(ctx context.Context, orgID int) (*models.Organization, error) {
+	ctx, span := tracer.Start(ctx, "OrgDeps.GetOrg")
+	defer span.End()
+
+	org, err := scanOrg(o.db.QueryRowContext(ctx, "SELECT id, name, settings, created_at FROM organizations WHERE id = $1", orgID))
+	if err == sql.ErrNoRows {
+		return nil, ErrOrgNotFound
+	}
+	return org, err
+}
+
+// CreateOrg creates an organization together with its default board
+func (o OrgDeps) CreateOrg(ctx context.Context, name string, settings models.OrgSettings) (*models.Organization, error) {
+	ctx, span := tracer.Start(ctx, "OrgDeps.CreateOrg")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, ErrInvalidOrg
+	}
+	settingsJSON, err := o.marshalSettings(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	org, err := scanOrg(tx.QueryRowContext(ctx, `
+		INSERT INTO organizations (name, settings) VALUES ($1, $2)
+		RETURNING id, name, settings, created_at
+	`, name, settingsJSON))
+	if err != nil {
+		return nil, orgNameError(err)
+	}
+
+	if _, _, err = insertBoard(ctx, tx, org.ID, nil, name, true); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// UpdateOrg changes the name and the settings set in the request, the others
+// keep their value
+func (o OrgDeps) UpdateOrg(ctx context.Context, orgID int, req models.OrgUpdateRequest) (*models.Organization, error) {
+	ctx, span := tracer.Start(ctx, "OrgDeps.UpdateOrg")
+	defer span.End()
+
+	var name string
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 255 {
+			return nil, ErrInvalidOrg
+		}
+	}
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The row is locked so concurrent updates of different settings both apply
+	org, err := scanOrg(tx.QueryRowContext(ctx, "SELECT id, name, settings, created_at FROM organizations WHERE id = $1 FOR UPDATE", orgID))
+	if err == sql.ErrNoRows {
+		return nil, ErrOrgNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings := org.Settings
+	if req.Settings.DefaultRole != nil {
+		settings.DefaultRole = *req.Settings.DefaultRole
+	}
+	if req.Settings.DefaultBoardRole != nil {
+		settings.DefaultBoardRole = *req.Settings.DefaultBoardRole
+	}
+	if req.Settings.RequireTwoFactor != nil {
+		settings.RequireTwoFactor = *req.Settings.RequireTwoFactor
+	}
+	settingsJSON, err := o.marshalSettings(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err = scanOrg(tx.QueryRowContext(ctx, `
+		UPDATE organizations
+		SET name = COALESCE(NULLIF($1, ''), name), settings = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING id, name, settings, created_at
+	`, name, settingsJSON, orgID))
+	if err != nil {
+		return nil, orgNameError(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// DefaultRole returns the role new users of the organization get
+func (o OrgDeps) DefaultRole(ctx context.Context, orgID int) (string, error) {
+	ctx, span := tracer.Start(ctx, "OrgDeps.DefaultRole")
+	defer span.End()
+
+	return orgDefaultRole(ctx, o.db, orgID)
+}
+
+func orgDefaultRole(ctx context.Context, db *sql.DB, orgID int) (string, error) {
+	var role string
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(settings->>'defaultRole', '') FROM organizations WHERE id = $1", orgID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrOrgNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		role = models.RoleMember
+	}
+	return role, nil
+}
+
+func (o OrgDeps) marshalSettings(ctx context.Context, settings models.OrgSettings) (string, error) {
+	if settings.DefaultRole != "" {
+		exists, err := o.roles.Exists(ctx, settings.DefaultRole)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", ErrInvalidSettings
+		}
+	}
+	if settings.DefaultBoardRole != "" && settings.DefaultBoardRole != "none" && !models.IsBoardRole(settings.DefaultBoardRole) {
+		return "", ErrInvalidSettings
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	return string(settingsJSON), nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrg(row rowScanner) (*models.Organization, error) {
+	var org models.Organization
+	var settings []byte
+	if err := row.Scan(&org.ID, &org.Name, &settings, &org.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings, &org.Settings); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func orgNameError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrOrgNameInUse
+	}
+	return err
+}
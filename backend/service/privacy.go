@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+type PrivacyDeps struct {
+	db *sql.DB
+}
+
+func NewPrivacyDeps(db *sql.DB) *PrivacyDeps {
+	return &PrivacyDeps{
+		db: db,
+	}
+}
+
+// ExportUser collects the personal data of a user of the organization,
+// ErrUserNotFound when there is no such user
+func (p PrivacyDeps) ExportUser(ctx context.Context, orgID int, userID string) (*models.UserExport, error) {
+	ctx, span := tracer.Start(ctx, "PrivacyDeps.ExportUser")
+	defer span.End()
+
+	export := models.UserExport{ExportedAt: time.Now()}
+	user := &export.Profile
+	err := p.db.QueryRowContext(ctx, `
+		SELECT id, username, email, role, org_id, is_super_admin, email_verified, deactivated_at IS NULL, created_at
+		FROM users WHERE id = $1 AND org_id = $2 AND erased_at IS NULL
+	`, userID, orgID).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.EmailVerified, &user.Active, &user.CreatedAt)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+
+	if export.Comments, err = p.exportComments(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.AssignedTasks, err = p.exportTasks(ctx, "JOIN task_assignees a ON a.task_id = t.id WHERE a.user_id = $1", userID); err != nil {
+		return nil, err
+	}
+	if export.CreatedTasks, err = p.exportTasks(ctx, "WHERE t.created_by = $1", userID); err != nil {
+		return nil, err
+	}
+	if export.Notifications, err = (NotificationsDeps{db: p.db}).GetNotification(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Boards, err = (BoardDeps{db: p.db}).GetBoards(ctx, orgID, userID, false); err != nil {
+		return nil, err
+	}
+	if export.Groups, err = p.exportGroups(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.APITokens, err = (TokenDeps{db: p.db}).GetTokens(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.SavedFilters, err = listFilters(ctx, p.db, "WHERE f.user_id = $1", userID); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (p PrivacyDeps) exportComments(ctx context.Context, userID string) ([]models.ExportedComment, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT c.id, c.task_id, t.title, c.content, c.created_at
+		FROM comments c
+		JOIN tasks t ON t.id = c.task_id
+		WHERE c.author = $1
+		ORDER BY c.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []models.ExportedComment{}
+	for rows.Next() {
+		var comment models.ExportedComment
+		if err := rows.Scan(&comment.ID, &comment.TaskID, &comment.TaskTitle, &comment.Content, &comment.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// exportTasks lists the tasks matching the filter on tasks t
+func (p PrivacyDeps) exportTasks(ctx context.Context, filter string, args ...interface{}) ([]models.ExportedTask, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT t.id, t.board_id, b.name, t.title, COALESCE(t.description, ''), t.state, t.priority, t.labels, t.created_at, t.updated_at
+		FROM tasks t
+		JOIN board_config b ON b.id = t.board_id
+		`+filter+`
+		ORDER BY t.created_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []models.ExportedTask{}
+	for rows.Next() {
+		var task models.ExportedTask
+		if err := rows.Scan(&task.ID, &task.BoardID, &task.BoardName, &task.Title, &task.Description, &task.State, &task.Priority,
+			pq.Array(&task.Labels), &task.CreatedAt, &task.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (p PrivacyDeps) exportGroups(ctx context.Context, userID string) ([]models.ExportedGroup, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT g.id, g.name, m.created_at
+		FROM group_members m
+		JOIN groups g ON g.id = m.group_id
+		WHERE m.user_id = $1
+		ORDER BY g.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.ExportedGroup{}
+	for rows.Next() {
+		var group models.ExportedGroup
+		if err := rows.Scan(&group.ID, &group.Name, &group.AddedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// EraseUser anonymizes a user of the organization. The account is kept as a
+// deactivated tombstone without personal data, its comments and tasks stay on
+// the boards under the deleted user placeholder, and everything else tied to
+// it is removed
+func (p PrivacyDeps) EraseUser(ctx context.Context, orgID int, userID string) error {
+	ctx, span := tracer.Start(ctx, "PrivacyDeps.EraseUser")
+	defer span.End()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, `
+		SELECT email FROM users WHERE id = $1 AND org_id = $2 AND erased_at IS NULL FOR UPDATE
+	`, userID, orgID).Scan(&email)
+	if err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if err = keepSuperAdmin(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err = touchUserTasks(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	// The password is replaced by an unguessable one
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE comments SET author = NULL WHERE author = $1", []interface{}{userID}},
+		{"UPDATE tasks SET created_by = NULL WHERE created_by = $1", []interface{}{userID}},
+		{"DELETE FROM task_assignees WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM group_members WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM board_members WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM notifications WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM api_tokens WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM saved_filters WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM recovery_codes WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM user_action_tokens WHERE user_id = $1", []interface{}{userID}},
+		{"DELETE FROM board_invitations WHERE lower(email) = lower($1)", []interface{}{email}},
+		{"DELETE FROM user_invitations WHERE lower(email) = lower($1)", []interface{}{email}},
+		{`
+			UPDATE users
+			SET username = $2, email = $3, password = $4, email_verified = false, oidc_subject = NULL,
+			    totp_secret = NULL, totp_enabled = false, totp_required = false, totp_last_step = 0,
+			    failed_logins = 0, lockouts = 0, locked_until = NULL, is_super_admin = false,
+			    deactivated_at = COALESCE(deactivated_at, NOW()), erased_at = NOW()
+			WHERE id = $1
+		`, []interface{}{userID, models.DeletedUserName, fmt.Sprintf("erased-%s@invalid", userID), hex.EncodeToString(raw)}},
+	}
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
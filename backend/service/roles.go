@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+// roleCacheTTL bounds how long other instances keep using changed permissions
+const roleCacheTTL = 30 * time.Second
+
+var (
+	ErrRoleNotFound      = newError(KindNotFound, "role_not_found", "role not found")
+	ErrRoleBuiltIn       = newError(KindConflict, "role_built_in", "built-in roles can't be changed")
+	ErrRoleInUse         = newError(KindConflict, "role_in_use", "role is assigned to users")
+	ErrInvalidRole       = newError(KindValidation, "invalid_role", "invalid role")
+	ErrUnknownPermission = newError(KindValidation, "unknown_permission", "unknown permission")
+)
+
+type RoleDeps struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	cache    map[string]map[string]bool
+	loadedAt time.Time
+}
+
+func NewRoleDeps(db *sql.DB) *RoleDeps {
+	return &RoleDeps{
+		db: db,
+	}
+}
+
+func (r *RoleDeps) GetRoles(ctx context.Context) ([]models.Role, error) {
+	ctx, span := tracer.Start(ctx, "RoleDeps.GetRoles")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT name, description, permissions, built_in FROM roles ORDER BY built_in DESC, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions), &role.BuiltIn); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// Exists reports whether the role can be assigned to users
+func (r *RoleDeps) Exists(ctx context.Context, name string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "RoleDeps.Exists")
+	defer span.End()
+
+	perms, err := r.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := perms[name]
+	return ok, nil
+}
+
+// SaveRole creates or updates a custom role
+func (r *RoleDeps) SaveRole(ctx context.Context, role models.Role) (*models.Role, error) {
+	ctx, span := tracer.Start(ctx, "RoleDeps.SaveRole")
+	defer span.End()
+
+	if role.Name == "" || len(role.Name) > 50 {
+		return nil, ErrInvalidRole
+	}
+	for _, p := range role.Permissions {
+		if !models.IsPermission(p) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO roles (name, description, permissions)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE
+		SET description = EXCLUDED.description, permissions = EXCLUDED.permissions, updated_at = NOW()
+		WHERE NOT roles.built_in
+		RETURNING built_in
+	`, role.Name, role.Description, pq.Array(role.Permissions)).Scan(&role.BuiltIn)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleBuiltIn
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.invalidate()
+	return &role, nil
+}
+
+func (r *RoleDeps) DeleteRole(ctx context.Context, name string) error {
+	ctx, span := tracer.Start(ctx, "RoleDeps.DeleteRole")
+	defer span.End()
+
+	var builtIn bool
+	err := r.db.QueryRowContext(ctx, "SELECT built_in FROM roles WHERE name = $1", name).Scan(&builtIn)
+	if err == sql.ErrNoRows {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if builtIn {
+		return ErrRoleBuiltIn
+	}
+
+	var users int
+	if err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = $1", name).Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+
+	if _, err = r.db.ExecContext(ctx, "DELETE FROM roles WHERE name = $1 AND NOT built_in", name); err != nil {
+		return err
+	}
+	r.invalidate()
+	return nil
+}
+
+// Permissions returns the permission set of a role, unknown roles have none
+func (r *RoleDeps) Permissions(ctx context.Context, role string) (map[string]bool, error) {
+	ctx, span := tracer.Start(ctx, "RoleDeps.Permissions")
+	defer span.End()
+
+	perms, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return perms[role], nil
+}
+
+func (r *RoleDeps) invalidate() {
+	r.mu.Lock()
+	r.cache = nil
+	r.mu.Unlock()
+}
+
+func (r *RoleDeps) load(ctx context.Context) (map[string]map[string]bool, error) {
+	r.mu.RLock()
+	if r.cache != nil && time.Since(r.loadedAt) < roleCacheTTL {
+		cache := r.cache
+		r.mu.RUnlock()
+		return cache, nil
+	}
+	r.mu.RUnlock()
+
+	roles, err := r.GetRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cache := make(map[string]map[string]bool, len(roles))
+	for _, role := range roles {
+		set := make(map[string]bool, len(role.Permissions))
+		for _, p := range role.Permissions {
+			set[p] = true
+		}
+		cache[role.Name] = set
+	}
+
+	r.mu.Lock()
+	r.cache = cache
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return cache, nil
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+// taskCursor is the position after the last task of a page, the sort key of
+// the task and its id to break ties. It is sent to clients base64-encoded
+type taskCursor struct {
+	Sort     string    `json:"s"`
+	Order    string    `json:"o"`
+	Time     time.Time `json:"t,omitempty"`
+	Priority int       `json:"p,omitempty"`
+	ID       string    `json:"id"`
+}
+
+func (c taskCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTaskCursor(value string) (taskCursor, bool) {
+	var cursor taskCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return cursor, false
+	}
+	return cursor, isUUID(cursor.ID)
+}
+
+// taskSortColumns are the columns of the task list orders
+var taskSortColumns = map[string]string{
+	models.TaskSortCreated:  "t.created_at",
+	models.TaskSortUpdated:  "t.updated_at",
+	models.TaskSortPriority: "t.priority",
+}
+
+// ValidateTaskListFilter checks the filter of the task list and fills in the
+// default order and page size
+func ValidateTaskListFilter(filter *models.TaskListFilter) error {
+	f := fieldErrors{}
+	for i, state := range filter.States {
+		f.check(state != "" && len(state) <= maxColumnIDLength, fmt.Sprintf("state.%d", i), "unknown column")
+	}
+	if filter.AssigneeID != "" {
+		f.uuid(filter.AssigneeID, "assignee")
+	}
+	if filter.CreatorID != "" {
+		f.uuid(filter.CreatorID, "creator")
+	}
+	filter.Labels = f.labels(filter.Labels, "label")
+	if filter.PriorityMin != 0 {
+		f.priority(filter.PriorityMin, "priorityMin")
+	}
+	if filter.PriorityMax != 0 {
+		f.priority(filter.PriorityMax, "priorityMax")
+		f.check(filter.PriorityMax >= filter.PriorityMin, "priorityMax", "must not be below priorityMin")
+	}
+	f.check(filter.CreatedTo.IsZero() || !filter.CreatedTo.Before(filter.CreatedFrom), "createdTo", "must not be before createdFrom")
+	f.check(filter.UpdatedTo.IsZero() || !filter.UpdatedTo.Before(filter.UpdatedFrom), "updatedTo", "must not be before updatedFrom")
+	f.check(len(filter.Text) <= maxTitleLength, "q", fmt.Sprintf("must be at most %d characters", maxTitleLength))
+
+	if filter.Query != "" {
+		query, err := ParseTaskQuery(filter.Query)
+		if err != nil {
+			return err
+		}
+		if query.Ordered() {
+			field, desc, ok := query.single()
+			_, sortable := taskSortColumns[field]
+			f.check(ok && sortable, "query", "ORDER BY of the task list takes one of created, updated or priority")
+			f.check(filter.Sort == "" && filter.Order == "", "query", "ORDER BY can't be combined with sort and order")
+			if _, failed := f["query"]; !failed {
+				filter.Sort, filter.Order = field, models.SortAscending
+				if desc {
+					filter.Order = models.SortDescending
+				}
+			}
+		}
+	}
+	if filter.Sort == "" {
+		filter.Sort = models.TaskSortCreated
+	}
+	_, ok := taskSortColumns[filter.Sort]
+	f.check(ok, "sort", "must be created, updated or priority")
+	if filter.Order == "" {
+		filter.Order = models.SortDescending
+		if filter.Sort == models.TaskSortPriority {
+			filter.Order = models.SortAscending
+		}
+	}
+	f.check(filter.Order == models.SortAscending || filter.Order == models.SortDescending, "order", "must be asc or desc")
+
+	if filter.Limit == 0 {
+		filter.Limit = models.DefaultTaskPageSize
+	}
+	f.check(filter.Limit > 0 && filter.Limit <= models.MaxTaskPageSize, "limit",
+		fmt.Sprintf("must be between 1 and %d", models.MaxTaskPageSize))
+
+	if filter.Cursor != "" {
+		cursor, ok := decodeTaskCursor(filter.Cursor)
+		f.check(ok, "cursor", "is invalid")
+		f.check(cursor.Sort == filter.Sort && cursor.Order == filter.Order, "cursor", "belongs to another sort order")
+	}
+	return f.err()
+}
+
+// ListTasks returns a page of the tasks of the organization matching the
+// filter, checked with ValidateTaskListFilter. Only the boards the user is a
+// member of are listed unless all is set, the user is also me in the query.
+// Comments are left out
+func (t TaskDeps) ListTasks(ctx context.Context, orgID int, userID string, all bool, filter models.TaskListFilter) (*models.TaskPage, error) {
+	ctx, span := tracer.Start(ctx, "TaskDeps.ListTasks")
+	defer span.End()
+
+	query := `
+		SELECT t.id, t.board_id, t.title, t.description, t.state, t.priority,
+		       COALESCE(g.id::text, ''), COALESCE(g.name, ''), t.labels, t.version, t.created_at, t.updated_at
+		FROM tasks t
+		JOIN board_config b ON b.id = t.board_id
+		LEFT JOIN groups g ON g.id = t.group_id
+		WHERE b.org_id = $1 AND ($2 OR EXISTS (
+			SELECT 1 FROM board_members m WHERE m.board_id = t.board_id AND m.user_id = $3
+		))`
+	params := []interface{}{orgID, all, userID}
+	arg := func(value interface{}) string {
+		params = append(params, value)
+		return fmt.Sprintf("$%d", len(params))
+	}
+	where := func(condition string, values ...interface{}) {
+		args := make([]interface{}, len(values))
+		for i, value := range values {
+			args[i] = arg(value)
+		}
+		query += " AND " + fmt.Sprintf(condition, args...)
+	}
+
+	if filter.BoardID != 0 {
+		where("t.board_id = %s", filter.BoardID)
+	}
+	if len(filter.States) > 0 {
+		where("t.state = ANY(%s)", pq.Array(filter.States))
+	}
+	if filter.AssigneeID != "" {
+		where("EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = %s)", filter.AssigneeID)
+	}
+	if filter.CreatorID != "" {
+		where("t.created_by = %s", filter.CreatorID)
+	}
+	if len(filter.Labels) > 0 {
+		where("t.labels @> %s", pq.Array(filter.Labels))
+	}
+	if filter.PriorityMin != 0 {
+		where("t.priority >= %s", filter.PriorityMin)
+	}
+	if filter.PriorityMax != 0 {
+		where("t.priority <= %s", filter.PriorityMax)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		where("t.created_at >= %s", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		where("t.created_at < %s", filter.CreatedTo)
+	}
+	if !filter.UpdatedFrom.IsZero() {
+		where("t.updated_at >= %s", filter.UpdatedFrom)
+	}
+	if !filter.UpdatedTo.IsZero() {
+		where("t.updated_at < %s", filter.UpdatedTo)
+	}
+	if text := strings.TrimSpace(filter.Text); text != "" {
+		where("(t.title ILIKE %[1]s OR t.description ILIKE %[1]s)", "%"+escapeLike(text)+"%")
+	}
+
+	if filter.Query != "" {
+		taskQuery, err := ParseTaskQuery(filter.Query)
+		if err != nil {
+			return nil, err
+		}
+		query += " AND " + taskQuery.where(userID, arg)
+	}
+
+	after, orderBy := taskKeyset(filter, arg)
+	if after != "" {
+		query += " AND " + after
+	}
+	query += " ORDER BY " + orderBy + " LIMIT " + arg(filter.Limit+1)
+
+	rows, err := t.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.TaskPage{Tasks: []models.Task{}}
+	taskIDs := []string{}
+	for rows.Next() {
+		var task models.Task
+		err := rows.Scan(&task.ID, &task.BoardID, &task.Title, &task.Description,
+			&task.State, &task.Priority, &task.GroupID, &task.Group, pq.Array(&task.Labels), &task.Version, &task.CreatedAt, &task.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		page.Tasks = append(page.Tasks, task)
+		taskIDs = append(taskIDs, task.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// One more task than the page holds was loaded to know whether it is the last
+	if len(page.Tasks) > filter.Limit {
+		page.Tasks = page.Tasks[:filter.Limit]
+		taskIDs = taskIDs[:filter.Limit]
+		last := page.Tasks[filter.Limit-1]
+		cursor := taskCursor{Sort: filter.Sort, Order: filter.Order, ID: last.ID}
+		switch filter.Sort {
+		case models.TaskSortCreated:
+			cursor.Time = last.CreatedAt
+		case models.TaskSortUpdated:
+			cursor.Time = last.UpdatedAt
+		case models.TaskSortPriority:
+			cursor.Priority = last.Priority
+		}
+		page.NextCursor = cursor.encode()
+	}
+
+	assignees, err := taskAssignees(ctx, t.db, "WHERE t.id = ANY($1::uuid[])", pq.Array(taskIDs))
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Tasks {
+		setAssignees(&page.Tasks[i], assignees[page.Tasks[i].ID])
+	}
+	return page, nil
+}
+
+// taskKeyset returns the condition of the tasks after the cursor of the
+// filter, empty without a cursor, and the ORDER BY of the list. Pages continue
+// after the cursor in the order of the sort key and the id
+func taskKeyset(filter models.TaskListFilter, arg func(interface{}) string) (after, orderBy string) {
+	column := taskSortColumns[filter.Sort]
+	direction, operator := "DESC", "<"
+	if filter.Order == models.SortAscending {
+		direction, operator = "ASC", ">"
+	}
+	if filter.Cursor != "" {
+		cursor, _ := decodeTaskCursor(filter.Cursor)
+		var key interface{} = cursor.Time
+		if filter.Sort == models.TaskSortPriority {
+			key = cursor.Priority
+		}
+		after = fmt.Sprintf("(%s, t.id) %s (%s, %s)", column, operator, arg(key), arg(cursor.ID))
+	}
+	return after, fmt.Sprintf("%[1]s %[2]s, t.id %[2]s", column, direction)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
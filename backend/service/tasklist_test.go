@@ -0,0 +1,161 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"belykh-ik/taskflow/models"
+)
+
+const testTaskID = "7f8e2c1a-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+
+func TestTaskCursorRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 15, 123456000, time.UTC)
+	cursors := []taskCursor{
+		{Sort: models.TaskSortCreated, Order: models.SortDescending, Time: created, ID: testTaskID},
+		{Sort: models.TaskSortUpdated, Order: models.SortAscending, Time: created, ID: testTaskID},
+		{Sort: models.TaskSortPriority, Order: models.SortAscending, Priority: models.PriorityLow, ID: testTaskID},
+	}
+	for _, cursor := range cursors {
+		got, ok := decodeTaskCursor(cursor.encode())
+		if !ok {
+			t.Fatalf("decodeTaskCursor(%+v) failed", cursor)
+		}
+		if !got.Time.Equal(cursor.Time) || got.Sort != cursor.Sort || got.Order != cursor.Order ||
+			got.Priority != cursor.Priority || got.ID != cursor.ID {
+			t.Errorf("round trip = %+v, want %+v", got, cursor)
+		}
+	}
+}
+
+func TestDecodeTaskCursorMalformed(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := map[string]string{
+		"empty":          "",
+		"not base64":     "not a cursor!",
+		"padded base64":  base64.URLEncoding.EncodeToString([]byte(`{"s":"created","o":"desc","id":"` + testTaskID + `"}`)),
+		"not JSON":       encode("created,desc"),
+		"missing id":     encode(`{"s":"created","o":"desc"}`),
+		"id not a uuid":  encode(`{"s":"created","o":"desc","id":"1 OR 1=1"}`),
+		"time malformed": encode(`{"s":"created","o":"desc","t":"yesterday","id":"` + testTaskID + `"}`),
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := decodeTaskCursor(value); ok {
+				t.Errorf("decodeTaskCursor(%q) accepted", value)
+			}
+		})
+	}
+}
+
+func TestValidateTaskListFilterCursor(t *testing.T) {
+	cursor := taskCursor{Sort: models.TaskSortCreated, Order: models.SortDescending, Time: time.Now(), ID: testTaskID}.encode()
+	tests := []struct {
+		name   string
+		filter models.TaskListFilter
+		err    string
+	}{
+		{"same sort", models.TaskListFilter{Cursor: cursor}, ""},
+		{"explicit same sort", models.TaskListFilter{Sort: models.TaskSortCreated, Order: models.SortDescending, Cursor: cursor}, ""},
+		{"other order", models.TaskListFilter{Order: models.SortAscending, Cursor: cursor}, "belongs to another sort order"},
+		{"other sort", models.TaskListFilter{Sort: models.TaskSortUpdated, Cursor: cursor}, "belongs to another sort order"},
+		{"query order", models.TaskListFilter{Query: "ORDER BY priority", Cursor: cursor}, "belongs to another sort order"},
+		{"malformed", models.TaskListFilter{Cursor: "garbage"}, "is invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := tt.filter
+			err := ValidateTaskListFilter(&filter)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			e, ok := AsError(err)
+			if !ok || e.Fields["cursor"] != tt.err {
+				t.Errorf("error = %v, want cursor %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateTaskListFilterLabels(t *testing.T) {
+	filter := models.TaskListFilter{Labels: []string{" bug ", "ui", "bug"}}
+	if err := ValidateTaskListFilter(&filter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"bug", "ui"}; !reflect.DeepEqual(filter.Labels, want) {
+		t.Errorf("labels = %q, want %q", filter.Labels, want)
+	}
+
+	filter = models.TaskListFilter{Labels: []string{"bug", strings.Repeat("x", maxLabelLength+1)}}
+	err := ValidateTaskListFilter(&filter)
+	if e, ok := AsError(err); !ok || e.Fields["label.1"] == "" {
+		t.Errorf("error = %v, want label.1", err)
+	}
+}
+
+func TestTaskKeyset(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sort, order    string
+		cursor         taskCursor
+		after, orderBy string
+		key            interface{}
+	}{
+		{models.TaskSortCreated, models.SortDescending, taskCursor{Time: at},
+			"(t.created_at, t.id) < ($1, $2)", "t.created_at DESC, t.id DESC", at},
+		{models.TaskSortCreated, models.SortAscending, taskCursor{Time: at},
+			"(t.created_at, t.id) > ($1, $2)", "t.created_at ASC, t.id ASC", at},
+		{models.TaskSortUpdated, models.SortDescending, taskCursor{Time: at},
+			"(t.updated_at, t.id) < ($1, $2)", "t.updated_at DESC, t.id DESC", at},
+		{models.TaskSortUpdated, models.SortAscending, taskCursor{Time: at},
+			"(t.updated_at, t.id) > ($1, $2)", "t.updated_at ASC, t.id ASC", at},
+		{models.TaskSortPriority, models.SortDescending, taskCursor{Priority: models.PriorityMedium},
+			"(t.priority, t.id) < ($1, $2)", "t.priority DESC, t.id DESC", models.PriorityMedium},
+		{models.TaskSortPriority, models.SortAscending, taskCursor{Priority: models.PriorityMedium},
+			"(t.priority, t.id) > ($1, $2)", "t.priority ASC, t.id ASC", models.PriorityMedium},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort+" "+tt.order, func(t *testing.T) {
+			cursor := tt.cursor
+			cursor.Sort, cursor.Order, cursor.ID = tt.sort, tt.order, testTaskID
+			filter := models.TaskListFilter{Sort: tt.sort, Order: tt.order, Cursor: cursor.encode()}
+			if err := ValidateTaskListFilter(&filter); err != nil {
+				t.Fatal(err)
+			}
+
+			var params []interface{}
+			arg := func(value interface{}) string {
+				params = append(params, value)
+				return fmt.Sprintf("$%d", len(params))
+			}
+			after, orderBy := taskKeyset(filter, arg)
+			if after != tt.after || orderBy != tt.orderBy {
+				t.Errorf("taskKeyset = %q, %q, want %q, %q", after, orderBy, tt.after, tt.orderBy)
+			}
+			if len(params) != 2 || params[1] != testTaskID {
+				t.Fatalf("params = %v", params)
+			}
+			if key, ok := params[0].(time.Time); ok {
+				if !key.Equal(tt.key.(time.Time)) {
+					t.Errorf("key = %v, want %v", key, tt.key)
+				}
+			} else if !reflect.DeepEqual(params[0], tt.key) {
+				t.Errorf("key = %v, want %v", params[0], tt.key)
+			}
+
+			// Without a cursor the list starts at the beginning in the same order
+			filter.Cursor, params = "", nil
+			after, orderBy = taskKeyset(filter, arg)
+			if after != "" || orderBy != tt.orderBy || len(params) != 0 {
+				t.Errorf("first page = %q, %q, %v", after, orderBy, params)
+			}
+		})
+	}
+}
@@ -0,0 +1,651 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+const maxTaskQueryLength = 1000
+
+// TaskQuery is a parsed task query such as
+//
+//	assignee = me AND priority <= 2 AND state != done ORDER BY updated DESC
+//
+// A condition compares a field with a value (=, !=, <, <=, >, >=), a list of
+// values ([NOT] IN (a, b)) or a text it contains (~, !~). Conditions are
+// combined with AND, OR, NOT and parentheses, keywords are case-insensitive
+// and values with spaces are quoted. The fields are:
+//
+//	state     column id                   = != IN
+//	priority  1 to 3                      = != < <= > >= IN
+//	assignee  me, none, user id or name   = != IN
+//	group     none, group id or name      = != IN
+//	title     text                        = != ~ !~
+//	text      title or description        ~ !~
+//	created   RFC 3339 time or date       < <= > >=
+//	updated   RFC 3339 time or date       < <= > >=
+//
+// and ORDER BY takes created, updated, priority or title, ASC or DESC. A date
+// is a whole day in UTC, created <= 2024-03-01 includes that day. The query is
+// compiled to SQL on tasks t or matched against loaded tasks, both select the
+// same tasks
+type TaskQuery struct {
+	cond  queryNode
+	order []queryOrder
+}
+
+type queryOrder struct {
+	field string
+	desc  bool
+}
+
+// queryNode is a condition of a query
+type queryNode interface {
+	match(task *models.Task, userID string) bool
+	sql(userID string, arg func(interface{}) string) string
+}
+
+// Operators of the fields, != and !~ are negations of = and ~
+var queryFields = map[string][]string{
+	"state":    {"=", "!=", "in"},
+	"priority": {"=", "!=", "<", "<=", ">", ">=", "in"},
+	"assignee": {"=", "!=", "in"},
+	"group":    {"=", "!=", "in"},
+	"title":    {"=", "!=", "~", "!~"},
+	"text":     {"~", "!~"},
+	"created":  {"<", "<=", ">", ">="},
+	"updated":  {"<", "<=", ">", ">="},
+}
+
+// queryColumns are the columns of the ordered fields
+var queryColumns = map[string]string{
+	"priority": "t.priority",
+	"created":  "t.created_at",
+	"updated":  "t.updated_at",
+	"title":    `lower(t.title) COLLATE "C"`,
+}
+
+// ParseTaskQuery parses a task query, errors are reported on the query field
+func ParseTaskQuery(text string) (*TaskQuery, error) {
+	if len(text) > maxTaskQueryLength {
+		return nil, ValidationError(map[string]string{"query": fmt.Sprintf("must be at most %d characters", maxTaskQueryLength)})
+	}
+	tokens, err := lexTaskQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	return p.parse()
+}
+
+// Match reports whether the task matches the conditions of the query, me is
+// the user userID
+func (q *TaskQuery) Match(task *models.Task, userID string) bool {
+	return q.cond == nil || q.cond.match(task, userID)
+}
+
+// Ordered reports whether the query has an ORDER BY
+func (q *TaskQuery) Ordered() bool {
+	return len(q.order) > 0
+}
+
+// Less orders two tasks like the ORDER BY of the query, ties are broken by id
+func (q *TaskQuery) Less(a, b *models.Task) bool {
+	for _, order := range q.order {
+		var c int
+		switch order.field {
+		case "priority":
+			c = cmp.Compare(a.Priority, b.Priority)
+		case "created":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case "updated":
+			c = a.UpdatedAt.Compare(b.UpdatedAt)
+		case "title":
+			c = strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+		}
+		if order.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return a.ID < b.ID
+}
+
+// where compiles the conditions of the query on tasks t, arg adds a parameter
+// and returns its placeholder
+func (q *TaskQuery) where(userID string, arg func(interface{}) string) string {
+	if q.cond == nil {
+		return "TRUE"
+	}
+	return q.cond.sql(userID, arg)
+}
+
+// single returns the field and the direction of an ORDER BY with one key
+func (q *TaskQuery) single() (field string, desc bool, ok bool) {
+	if len(q.order) != 1 {
+		return "", false, false
+	}
+	return q.order[0].field, q.order[0].desc, true
+}
+
+type queryAnd struct{ left, right queryNode }
+
+func (n queryAnd) match(task *models.Task, userID string) bool {
+	return n.left.match(task, userID) && n.right.match(task, userID)
+}
+
+func (n queryAnd) sql(userID string, arg func(interface{}) string) string {
+	return "(" + n.left.sql(userID, arg) + " AND " + n.right.sql(userID, arg) + ")"
+}
+
+type queryOr struct{ left, right queryNode }
+
+func (n queryOr) match(task *models.Task, userID string) bool {
+	return n.left.match(task, userID) || n.right.match(task, userID)
+}
+
+func (n queryOr) sql(userID string, arg func(interface{}) string) string {
+	return "(" + n.left.sql(userID, arg) + " OR " + n.right.sql(userID, arg) + ")"
+}
+
+type queryNot struct{ node queryNode }
+
+func (n queryNot) match(task *models.Task, userID string) bool {
+	return !n.node.match(task, userID)
+}
+
+func (n queryNot) sql(userID string, arg func(interface{}) string) string {
+	return "NOT (" + n.node.sql(userID, arg) + ")"
+}
+
+// queryValue is a value of a condition, converted for its field
+type queryValue struct {
+	text string
+	num  int
+	time time.Time
+	// date is set when time is the start of a day given without a time
+	date bool
+	// me and none are the unquoted keywords of assignee and group
+	me, none bool
+}
+
+// queryCompare is a condition on a field, op is in, ~ or an ordering
+// operator. = is parsed as in with one value
+type queryCompare struct {
+	field  string
+	op     string
+	values []queryValue
+}
+
+func (c queryCompare) match(task *models.Task, userID string) bool {
+	switch c.op {
+	case "in":
+		for _, value := range c.values {
+			if c.equal(task, value, userID) {
+				return true
+			}
+		}
+		return false
+	case "~":
+		needle := strings.ToLower(c.values[0].text)
+		if strings.Contains(strings.ToLower(task.Title), needle) {
+			return true
+		}
+		return c.field == "text" && strings.Contains(strings.ToLower(task.Description), needle)
+	}
+
+	var result int
+	switch c.field {
+	case "priority":
+		result = cmp.Compare(task.Priority, c.values[0].num)
+	case "created":
+		result = task.CreatedAt.Compare(c.values[0].time)
+	case "updated":
+		result = task.UpdatedAt.Compare(c.values[0].time)
+	}
+	switch c.op {
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	default:
+		return result >= 0
+	}
+}
+
+func (c queryCompare) equal(task *models.Task, value queryValue, userID string) bool {
+	switch c.field {
+	case "state":
+		return task.State == value.text
+	case "priority":
+		return task.Priority == value.num
+	case "title":
+		return strings.EqualFold(task.Title, value.text)
+	case "assignee":
+		if value.none {
+			return len(task.Assignees) == 0
+		}
+		for _, assignee := range task.Assignees {
+			if value.me && assignee.ID == userID {
+				return true
+			}
+			if !value.me && (strings.EqualFold(assignee.ID, value.text) || strings.EqualFold(assignee.Username, value.text)) {
+				return true
+			}
+		}
+		return false
+	case "group":
+		if value.none {
+			return task.GroupID == ""
+		}
+		return task.GroupID != "" && (strings.EqualFold(task.GroupID, value.text) || strings.EqualFold(task.Group, value.text))
+	}
+	return false
+}
+
+func (c queryCompare) sql(userID string, arg func(interface{}) string) string {
+	switch c.op {
+	case "~":
+		pattern := arg("%" + escapeLike(c.values[0].text) + "%")
+		if c.field == "text" {
+			return fmt.Sprintf("(t.title ILIKE %[1]s OR COALESCE(t.description, '') ILIKE %[1]s)", pattern)
+		}
+		return "t.title ILIKE " + pattern
+	case "in":
+	default:
+		return queryColumns[c.field] + " " + c.op + " " + arg(c.sqlValue(c.values[0]))
+	}
+
+	switch c.field {
+	case "state":
+		states := make([]string, len(c.values))
+		for i, value := range c.values {
+			states[i] = value.text
+		}
+		return "t.state = ANY(" + arg(pq.Array(states)) + ")"
+	case "priority":
+		priorities := make([]int64, len(c.values))
+		for i, value := range c.values {
+			priorities[i] = int64(value.num)
+		}
+		return "t.priority = ANY(" + arg(pq.Array(priorities)) + ")"
+	case "title":
+		titles := make([]string, len(c.values))
+		for i, value := range c.values {
+			titles[i] = strings.ToLower(value.text)
+		}
+		return "lower(t.title) = ANY(" + arg(pq.Array(titles)) + ")"
+	}
+
+	conditions := make([]string, len(c.values))
+	for i, value := range c.values {
+		switch {
+		case c.field == "assignee" && value.none:
+			conditions[i] = "NOT EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = t.id)"
+		case c.field == "assignee" && value.me:
+			conditions[i] = "EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = " + arg(userID) + ")"
+		case c.field == "assignee":
+			conditions[i] = fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM task_assignees a JOIN users u ON u.id = a.user_id
+				WHERE a.task_id = t.id AND (u.id::text = lower(%[1]s) OR lower(u.username) = lower(%[1]s))
+			)`, arg(value.text))
+		case value.none:
+			conditions[i] = "t.group_id IS NULL"
+		default:
+			conditions[i] = fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM groups qg
+				WHERE qg.id = t.group_id AND (qg.id::text = lower(%[1]s) OR lower(qg.name) = lower(%[1]s))
+			)`, arg(value.text))
+		}
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+func (c queryCompare) sqlValue(value queryValue) interface{} {
+	if c.field == "priority" {
+		return value.num
+	}
+	return value.time
+}
+
+// Tokens of the query language
+const (
+	tokenEOF = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type queryToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func queryError(pos int, format string, args ...interface{}) error {
+	return ValidationError(map[string]string{"query": fmt.Sprintf(format, args...) + fmt.Sprintf(" at position %d", pos+1)})
+}
+
+// isWordRune reports whether the rune is part of an unquoted word, which
+// covers ids, dates and times
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:@+", r)
+}
+
+func lexTaskQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, queryToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var value strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, queryError(start, "unterminated string")
+			}
+			tokens = append(tokens, queryToken{kind: tokenString, text: value.String(), pos: start})
+			i++
+		case strings.ContainsRune("=!<>~", r):
+			start := i
+			op := string(r)
+			if i+1 < len(runes) && ((r != '=' && r != '~' && runes[i+1] == '=') || (r == '!' && runes[i+1] == '~')) {
+				op += string(runes[i+1])
+			}
+			if op == "!" {
+				return nil, queryError(start, "unknown operator !")
+			}
+			tokens = append(tokens, queryToken{kind: tokenOperator, text: op, pos: start})
+			i += len([]rune(op))
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, queryToken{kind: tokenWord, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, queryError(i, "unexpected %q", r)
+		}
+	}
+	return append(tokens, queryToken{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// queryParser is a recursive descent parser of the query language:
+//
+//	query      = [or] [ORDER BY order {"," order}]
+//	or         = and {OR and}
+//	and        = not {AND not}
+//	not        = NOT not | "(" or ")" | comparison
+//	comparison = field operator value | field [NOT] IN "(" value {"," value} ")"
+//	order      = field [ASC | DESC]
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// keyword consumes the next token when it is the unquoted keyword
+func (p *queryParser) keyword(word string) bool {
+	token := p.peek()
+	if token.kind == tokenWord && strings.EqualFold(token.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) unexpected(token queryToken) error {
+	if token.kind == tokenEOF {
+		return queryError(token.pos, "unexpected end of query")
+	}
+	return queryError(token.pos, "unexpected %q", token.text)
+}
+
+func (p *queryParser) parse() (*TaskQuery, error) {
+	query := &TaskQuery{}
+	if token := p.peek(); token.kind != tokenEOF && !(token.kind == tokenWord && strings.EqualFold(token.text, "order")) {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		query.cond = cond
+	}
+	if p.keyword("order") {
+		if !p.keyword("by") {
+			return nil, p.unexpected(p.peek())
+		}
+		for {
+			token := p.next()
+			field := strings.ToLower(token.text)
+			if _, ok := queryColumns[field]; token.kind != tokenWord || !ok {
+				return nil, queryError(token.pos, "can't order by %q", token.text)
+			}
+			order := queryOrder{field: field}
+			if p.keyword("desc") {
+				order.desc = true
+			} else {
+				p.keyword("asc")
+			}
+			query.order = append(query.order, order)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if token := p.peek(); token.kind != tokenEOF {
+		return nil, p.unexpected(token)
+	}
+	return query, nil
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = queryOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = queryAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.keyword("not") {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{node}, nil
+	}
+	if p.peek().kind == tokenOpen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token := p.next(); token.kind != tokenClose {
+			return nil, p.unexpected(token)
+		}
+		return node, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	token := p.next()
+	if token.kind != tokenWord {
+		return nil, p.unexpected(token)
+	}
+	field := strings.ToLower(token.text)
+	operators, ok := queryFields[field]
+	if !ok {
+		return nil, queryError(token.pos, "unknown field %q", token.text)
+	}
+
+	opToken := p.peek()
+	var op string
+	switch {
+	case opToken.kind == tokenOperator:
+		op = p.next().text
+	case p.keyword("in"):
+		op = "in"
+	case p.keyword("not"):
+		if !p.keyword("in") {
+			return nil, p.unexpected(p.peek())
+		}
+		op = "!in"
+	default:
+		return nil, p.unexpected(opToken)
+	}
+	if !contains(operators, strings.TrimPrefix(op, "!")) && !contains(operators, op) {
+		return nil, queryError(opToken.pos, "%s doesn't take %s", field, strings.ToUpper(op))
+	}
+
+	var values []queryValue
+	if strings.HasSuffix(op, "in") {
+		if token := p.next(); token.kind != tokenOpen {
+			return nil, p.unexpected(token)
+		}
+		for {
+			value, err := p.parseValue(field)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			token := p.next()
+			if token.kind == tokenClose {
+				break
+			}
+			if token.kind != tokenComma {
+				return nil, p.unexpected(token)
+			}
+		}
+	} else {
+		value, err := p.parseValue(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	// = is an IN with one value, the negated operators wrap the positive one
+	negate := strings.HasPrefix(op, "!")
+	op = strings.TrimPrefix(op, "!")
+	if op == "=" {
+		op = "in"
+	}
+	// A date covers the whole day, <= and > compare with the start of the next
+	if values[0].date && (op == "<=" || op == ">") {
+		values[0].time = values[0].time.AddDate(0, 0, 1)
+		op = map[string]string{"<=": "<", ">": ">="}[op]
+	}
+	var node queryNode = queryCompare{field: field, op: op, values: values}
+	if negate {
+		node = queryNot{node}
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseValue(field string) (queryValue, error) {
+	token := p.next()
+	if token.kind != tokenWord && token.kind != tokenString {
+		return queryValue{}, p.unexpected(token)
+	}
+	value := queryValue{text: token.text}
+	keyword := token.kind == tokenWord
+	switch field {
+	case "priority":
+		n, err := strconv.Atoi(token.text)
+		if err != nil || n < models.PriorityHigh || n > models.PriorityLow {
+			return value, queryError(token.pos, "priority takes a number between %d and %d", models.PriorityHigh, models.PriorityLow)
+		}
+		value.num = n
+	case "created", "updated":
+		t, err := time.Parse(time.RFC3339, token.text)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, token.text); err != nil {
+				return value, queryError(token.pos, "%s takes an RFC 3339 time or a date", field)
+			}
+			value.date = true
+		}
+		value.time = t
+	case "assignee":
+		value.me = keyword && strings.EqualFold(token.text, "me")
+		value.none = keyword && strings.EqualFold(token.text, "none")
+	case "group":
+		value.none = keyword && strings.EqualFold(token.text, "none")
+	}
+	return value, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+func TestLexTaskQuery(t *testing.T) {
+	tokens, err := lexTaskQuery(`state IN (done, "in progress") AND title !~ 'it\'s' OR created>=2024-03-01T10:00:00+03:00`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []queryToken{
+		{tokenWord, "state", 0},
+		{tokenWord, "IN", 6},
+		{tokenOpen, "(", 9},
+		{tokenWord, "done", 10},
+		{tokenComma, ",", 14},
+		{tokenString, "in progress", 16},
+		{tokenClose, ")", 29},
+		{tokenWord, "AND", 31},
+		{tokenWord, "title", 35},
+		{tokenOperator, "!~", 41},
+		{tokenString, "it's", 44},
+		{tokenWord, "OR", 52},
+		{tokenWord, "created", 55},
+		{tokenOperator, ">=", 62},
+		{tokenWord, "2024-03-01T10:00:00+03:00", 64},
+		{tokenEOF, "", 89},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("lexTaskQuery =\n%v\nwant\n%v", tokens, want)
+	}
+}
+
+func TestLexTaskQueryOperators(t *testing.T) {
+	for _, op := range []string{"=", "!=", "<", "<=", ">", ">=", "~", "!~"} {
+		tokens, err := lexTaskQuery("priority" + op + "2")
+		if err != nil {
+			t.Fatalf("%s: %v", op, err)
+		}
+		if len(tokens) != 4 || tokens[1].kind != tokenOperator || tokens[1].text != op {
+			t.Errorf("%s lexed as %v", op, tokens)
+		}
+	}
+}
+
+// whereSQL compiles the conditions of the query with numbered placeholders
+func whereSQL(q *TaskQuery, userID string) (string, []interface{}) {
+	var params []interface{}
+	where := q.where(userID, func(value interface{}) string {
+		params = append(params, value)
+		return fmt.Sprintf("$%d", len(params))
+	})
+	return where, params
+}
+
+func TestParseTaskQueryPrecedence(t *testing.T) {
+	tests := []struct {
+		query, where string
+	}{
+		{"", "TRUE"},
+		{"state = a", "t.state = ANY($1)"},
+		{"state = a OR state = b AND state = c", "(t.state = ANY($1) OR (t.state = ANY($2) AND t.state = ANY($3)))"},
+		{"state = a AND state = b OR state = c", "((t.state = ANY($1) AND t.state = ANY($2)) OR t.state = ANY($3))"},
+		{"(state = a OR state = b) AND state = c", "((t.state = ANY($1) OR t.state = ANY($2)) AND t.state = ANY($3))"},
+		{"NOT state = a AND state = b", "(NOT (t.state = ANY($1)) AND t.state = ANY($2))"},
+		{"NOT (state = a AND state = b)", "NOT ((t.state = ANY($1) AND t.state = ANY($2)))"},
+		{"not not state = a", "NOT (NOT (t.state = ANY($1)))"},
+		{"state != a", "NOT (t.state = ANY($1))"},
+		{"state NOT IN (a, b)", "NOT (t.state = ANY($1))"},
+		{"state = a or state = b or state = c", "((t.state = ANY($1) OR t.state = ANY($2)) OR t.state = ANY($3))"},
+		{"priority < 3 AND priority >= 1", "(t.priority < $1 AND t.priority >= $2)"},
+		{"title ~ bug", "t.title ILIKE $1"},
+		{"title !~ bug", "NOT (t.title ILIKE $1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseTaskQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where, _ := whereSQL(q, ""); where != tt.where {
+				t.Errorf("where = %s\nwant %s", where, tt.where)
+			}
+		})
+	}
+}
+
+func TestParseTaskQueryOrderBy(t *testing.T) {
+	tests := []struct {
+		query string
+		order []queryOrder
+		where string
+	}{
+		{"state = a", nil, "t.state = ANY($1)"},
+		{"ORDER BY priority", []queryOrder{{"priority", false}}, "TRUE"},
+		{"order by Updated desc", []queryOrder{{"updated", true}}, "TRUE"},
+		{"state = a ORDER BY priority ASC, created DESC, title",
+			[]queryOrder{{"priority", false}, {"created", true}, {"title", false}}, "t.state = ANY($1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseTaskQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(q.order, tt.order) {
+				t.Errorf("order = %v, want %v", q.order, tt.order)
+			}
+			if q.Ordered() != (tt.order != nil) {
+				t.Errorf("Ordered = %v", q.Ordered())
+			}
+			if where, _ := whereSQL(q, ""); where != tt.where {
+				t.Errorf("where = %s, want %s", where, tt.where)
+			}
+		})
+	}
+}
+
+func TestParseTaskQueryErrors(t *testing.T) {
+	tests := []struct {
+		query, err string
+	}{
+		{"state = a AND", "unexpected end of query at position 14"},
+		{"state = ", "unexpected end of query at position 9"},
+		{"colour = red", `unknown field "colour" at position 1`},
+		{"state < done", "state doesn't take < at position 7"},
+		{"text = bug", "text doesn't take = at position 6"},
+		{"priority = high", "priority takes a number between 1 and 3 at position 12"},
+		{"priority = 0", "priority takes a number between 1 and 3 at position 12"},
+		{"priority IN (1, 4)", "priority takes a number between 1 and 3 at position 17"},
+		{"created > yesterday", "created takes an RFC 3339 time or a date at position 11"},
+		{"title = 'open", "unterminated string at position 9"},
+		{"state = a # b", `unexpected '#' at position 11`},
+		{"state ! a", "unknown operator ! at position 7"},
+		{"(state = a", "unexpected end of query at position 11"},
+		{"state = a)", `unexpected ")" at position 10`},
+		{"state IN a", `unexpected "a" at position 10`},
+		{"state IN (a b)", `unexpected "b" at position 13`},
+		{"state NOT a", `unexpected "a" at position 11`},
+		{"ORDER priority", `unexpected "priority" at position 7`},
+		{"ORDER BY state", `can't order by "state" at position 10`},
+		{"ORDER BY priority state = a", `unexpected "state" at position 19`},
+		{"état = a", `unknown field "état" at position 1`},
+		{"title = ü AND", "unexpected end of query at position 14"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseTaskQuery(tt.query)
+			e, ok := AsError(err)
+			if !ok || e.Kind != KindValidation {
+				t.Fatalf("error = %v, want a validation error", err)
+			}
+			if e.Fields["query"] != tt.err {
+				t.Errorf("error = %q, want %q", e.Fields["query"], tt.err)
+			}
+		})
+	}
+
+	if _, err := ParseTaskQuery(strings.Repeat("a", maxTaskQueryLength+1)); err == nil {
+		t.Error("overlong query accepted")
+	}
+}
+
+// sqlTask evaluates the conditions generated by TaskQuery.where on a task, it
+// knows the fragments of queryCompare.sql and how they are combined
+func sqlTask(t *testing.T, where string, params []interface{}, task *models.Task, userID string) bool {
+	t.Helper()
+	where = strings.Join(strings.Fields(where), " ")
+	param := func(ref string) interface{} {
+		n, _ := strconv.Atoi(strings.TrimPrefix(ref, "$"))
+		return params[n-1]
+	}
+
+	if where == "TRUE" {
+		return true
+	}
+	if parts := splitSQL(where, " OR "); len(parts) > 1 {
+		for _, part := range parts {
+			if sqlTask(t, part, params, task, userID) {
+				return true
+			}
+		}
+		return false
+	}
+	if parts := splitSQL(where, " AND "); len(parts) > 1 {
+		for _, part := range parts {
+			if !sqlTask(t, part, params, task, userID) {
+				return false
+			}
+		}
+		return true
+	}
+	if strings.HasPrefix(where, "NOT (") && closes(where, 4) {
+		return !sqlTask(t, where[5:len(where)-1], params, task, userID)
+	}
+	if strings.HasPrefix(where, "(") && closes(where, 0) {
+		return sqlTask(t, where[1:len(where)-1], params, task, userID)
+	}
+
+	ilike := func(value, ref string) bool {
+		pattern := param(ref).(string)
+		needle := strings.NewReplacer(`\%`, "%", `\_`, "_", `\\`, `\`).Replace(pattern[1 : len(pattern)-1])
+		return strings.Contains(strings.ToLower(value), strings.ToLower(needle))
+	}
+	ref := regexp.MustCompile(`\$\d+`).FindString(where)
+	switch {
+	case strings.HasPrefix(where, "t.state = ANY("):
+		return contains(*param(ref).(*pq.StringArray), task.State)
+	case strings.HasPrefix(where, "lower(t.title) = ANY("):
+		return contains(*param(ref).(*pq.StringArray), strings.ToLower(task.Title))
+	case strings.HasPrefix(where, "t.priority = ANY("):
+		for _, p := range *param(ref).(*pq.Int64Array) {
+			if int64(task.Priority) == p {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(where, "t.title ILIKE "):
+		return ilike(task.Title, ref)
+	case strings.HasPrefix(where, "COALESCE(t.description, '') ILIKE "):
+		return ilike(task.Description, ref)
+	case where == "NOT EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = t.id)":
+		return len(task.Assignees) == 0
+	case strings.Contains(where, "FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = "):
+		for _, a := range task.Assignees {
+			if a.ID == param(ref) {
+				return true
+			}
+		}
+		return false
+	case strings.Contains(where, "FROM task_assignees a JOIN users u"):
+		value := strings.ToLower(param(ref).(string))
+		for _, a := range task.Assignees {
+			if a.ID == value || strings.ToLower(a.Username) == value {
+				return true
+			}
+		}
+		return false
+	case where == "t.group_id IS NULL":
+		return task.GroupID == ""
+	case strings.Contains(where, "FROM groups qg"):
+		value := strings.ToLower(param(ref).(string))
+		return task.GroupID != "" && (task.GroupID == value || strings.ToLower(task.Group) == value)
+	}
+
+	m := regexp.MustCompile(`^t\.(priority|created_at|updated_at) (<|<=|>|>=) (\$\d+)$`).FindStringSubmatch(where)
+	if m == nil {
+		t.Fatalf("unknown SQL %q", where)
+	}
+	var c int
+	switch m[1] {
+	case "priority":
+		c = task.Priority - param(m[3]).(int)
+	case "created_at":
+		c = task.CreatedAt.Compare(param(m[3]).(time.Time))
+	case "updated_at":
+		c = task.UpdatedAt.Compare(param(m[3]).(time.Time))
+	}
+	switch m[2] {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// splitSQL splits the SQL on the separator outside of parentheses
+func splitSQL(where, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(where); i++ {
+		switch where[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && strings.HasPrefix(where[i:], sep) {
+			parts = append(parts, where[start:i])
+			start = i + len(sep)
+		}
+	}
+	return append(parts, where[start:])
+}
+
+// closes reports whether the parenthesis at open closes at the end of the SQL
+func closes(where string, open int) bool {
+	depth := 0
+	for i := open; i < len(where); i++ {
+		switch where[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i == len(where)-1
+			}
+		}
+	}
+	return false
+}
+
+func TestTaskQueryMatchesSQL(t *testing.T) {
+	const me = "aaaaaaaa-0000-4000-8000-000000000001"
+	const other = "bbbbbbbb-0000-4000-8000-000000000002"
+	const design = "cccccccc-0000-4000-8000-000000000003"
+	day := func(d, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, time.UTC) }
+
+	tasks := []models.Task{
+		{ID: "1", Title: "Fix login bug", Description: "50% of logins fail", State: "backlog", Priority: 1,
+			Assignees: []models.TaskAssignee{{ID: me, Username: "Anna"}}, CreatedAt: day(1, 0), UpdatedAt: day(2, 12)},
+		{ID: "2", Title: "Write docs", Description: "API reference", State: "inprogress", Priority: 2,
+			GroupID: design, Group: "Design", CreatedAt: day(1, 23), UpdatedAt: day(3, 0)},
+		{ID: "3", Title: "fix LOGIN bug", State: "done", Priority: 3, GroupID: design, Group: "Design",
+			Assignees: []models.TaskAssignee{{ID: me, Username: "Anna"}, {ID: other, Username: "Boris"}},
+			CreatedAt: day(2, 0), UpdatedAt: day(2, 0)},
+		{ID: "4", Title: "under_score", Description: "snake_case names", State: "aprove", Priority: 2,
+			Assignees: []models.TaskAssignee{{ID: other, Username: "Boris"}}, CreatedAt: day(3, 5), UpdatedAt: day(4, 0)},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"1", "2", "3", "4"}},
+		{"state = done", []string{"3"}},
+		{"state != done", []string{"1", "2", "4"}},
+		{"state IN (backlog, inprogress)", []string{"1", "2"}},
+		{"state NOT IN (backlog, inprogress)", []string{"3", "4"}},
+		{"priority = 2", []string{"2", "4"}},
+		{"priority <= 2", []string{"1", "2", "4"}},
+		{"priority > 1 AND priority < 3", []string{"2", "4"}},
+		{"priority IN (1, 3)", []string{"1", "3"}},
+		{"assignee = me", []string{"1", "3"}},
+		{"assignee != me", []string{"2", "4"}},
+		{"assignee = none", []string{"2"}},
+		{"assignee = boris", []string{"3", "4"}},
+		{"assignee = 'me'", nil},
+		{"assignee = " + strings.ToUpper(other), []string{"3", "4"}},
+		{"assignee IN (me, none)", []string{"1", "2", "3"}},
+		{"group = none", []string{"1", "4"}},
+		{"group = design", []string{"2", "3"}},
+		{"group = " + design, []string{"2", "3"}},
+		{"group != Design", []string{"1", "4"}},
+		{"title = 'fix login bug'", []string{"1", "3"}},
+		{"title ~ LOGIN", []string{"1", "3"}},
+		{"title !~ bug", []string{"2", "4"}},
+		{"title ~ _", []string{"4"}},
+		{"text ~ reference", []string{"2"}},
+		{"text ~ '%'", []string{"1"}},
+		{"text ~ snake_", []string{"4"}},
+		{"created < 2024-03-02", []string{"1", "2"}},
+		{"created <= 2024-03-01", []string{"1", "2"}},
+		{"created > 2024-03-01", []string{"3", "4"}},
+		{"created >= 2024-03-02", []string{"3", "4"}},
+		{"created <= 2024-03-01T00:00:00Z", []string{"1"}},
+		{"created > 2024-03-01T00:00:00Z", []string{"2", "3", "4"}},
+		{"updated <= 2024-03-02", []string{"1", "3"}},
+		{"updated > 2024-03-02", []string{"2", "4"}},
+		{"updated >= 2024-03-02T12:00:00+02:00", []string{"1", "2", "4"}},
+		{"assignee = me AND priority <= 2 AND state != done ORDER BY updated DESC", []string{"1"}},
+		{"NOT (assignee = me OR group = design)", []string{"4"}},
+		{"state = done OR title ~ docs AND priority = 3", []string{"3"}},
+		{"(state = done OR title ~ docs) AND priority = 2", []string{"2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseTaskQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, params := whereSQL(q, me)
+			var got []string
+			for i := range tasks {
+				task := &tasks[i]
+				match := q.Match(task, me)
+				if sql := sqlTask(t, where, params, task, me); sql != match {
+					t.Errorf("task %s: Match = %v, SQL %s = %v", task.ID, match, where, sql)
+				}
+				if match {
+					got = append(got, task.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
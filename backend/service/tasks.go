@@ -1,12 +1,17 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
+	"belykh-ik/taskflow/logging"
+	"belykh-ik/taskflow/metrics"
 	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
 )
 
 type TaskDeps struct {
@@ -19,76 +24,113 @@ func NewTaskDeps(db *sql.DB) *TaskDeps {
 	}
 }
 
-func (t TaskDeps) CreateTask(userID string, task *models.Task) error {
-	// If no assignee is specified, set state to backlog
-	if task.Assignee == "" || task.Assignee == "null" {
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CreateTask adds a task to a board of the organization, assigned to
+// task.AssigneeIDs and task.GroupID
+func (t TaskDeps) CreateTask(ctx context.Context, orgID int, userID string, task *models.Task) error {
+	ctx, span := tracer.Start(ctx, "TaskDeps.CreateTask")
+	defer span.End()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = lockBoard(ctx, tx, orgID, task.BoardID); err != nil {
+		return err
+	}
+
+	// If nobody is assigned, set state to backlog
+	if task.State == "" || (len(task.AssigneeIDs) == 0 && task.GroupID == "") {
 		task.State = "backlog"
-		task.Assignee = ""
+	}
+	if err = checkState(ctx, tx, task.BoardID, task.State); err != nil {
+		return err
+	}
+	if task.Labels == nil {
+		task.Labels = []string{}
 	}
 
 	// Insert task into database
 	now := time.Now()
-	var assignee interface{}
-	if task.Assignee != "" && task.Assignee != "null" {
-		assignee = task.Assignee
-	} else {
-		assignee = nil
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO tasks (board_id, title, description, state, priority, group_id, labels, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, labels, version, created_at, updated_at
+	`, task.BoardID, task.Title, task.Description, task.State, task.Priority, nullString(task.GroupID), pq.Array(task.Labels), userID, now, now).Scan(&task.ID, pq.Array(&task.Labels), &task.Version, &task.CreatedAt, &task.UpdatedAt)
+	if err != nil {
+		return err
 	}
 
-	err := t.db.QueryRow(`
-		INSERT INTO tasks (title, description, state, priority, assignee, created_by, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, created_at, updated_at
-	`, task.Title, task.Description, task.State, task.Priority, assignee, userID, now, now).Scan(&task.ID, &task.CreatedAt, &task.UpdatedAt)
-
+	if err = replaceTaskAssignees(ctx, tx, task.ID, task.AssigneeIDs); err != nil {
+		return err
+	}
+	// Get assignee usernames and group name for response
+	if err = fillAssignment(ctx, tx, task); err != nil {
+		return err
+	}
+	recipients, err := taskRecipients(ctx, tx, task.ID)
 	if err != nil {
 		return err
 	}
-
-	// Get assignee username for response, but keep ID for notifications
-	if task.Assignee != "" && task.Assignee != "null" {
-		assigneeID := task.Assignee
-		var username string
-		if err = t.db.QueryRow("SELECT username FROM users WHERE id = $1", assigneeID).Scan(&username); err == nil {
-			task.Assignee = username
-		}
-
-		// Create notification for the assignee (use ID)
-		if _, err = t.db.Exec(`
-            INSERT INTO notifications (user_id, message, read, created_at)
-            VALUES ($1, $2, false, $3)
-        `, assigneeID, fmt.Sprintf("Вам назначена новая задача: %s", task.Title), now); err != nil {
-			log.Printf("Error creating notification: %v", err)
-		}
+	if err = tx.Commit(); err != nil {
+		return err
 	}
+	task.AssigneeIDs = nil
+	metrics.TasksCreated.Inc()
+
+	// Notify the assignees and the group
+	t.notifyUsers(ctx, recipients, fmt.Sprintf("Вам назначена новая задача: %s", task.Title))
 	return nil
 }
 
-func (t TaskDeps) GetTask(taskID string, task *models.Task) error {
-	var assignee sql.NullString
-	err := t.db.QueryRow(`
-		SELECT t.id, t.title, t.description, t.state, t.priority, u.username as assignee, t.created_at, t.updated_at
-		FROM tasks t
-		LEFT JOIN users u ON t.assignee = u.id
-		WHERE t.id = $1
-	`, taskID).Scan(&task.ID, &task.Title, &task.Description, &task.State, &task.Priority, &assignee, &task.CreatedAt, &task.UpdatedAt)
+// TaskBoard returns the board of a task, ErrTaskNotFound when it doesn't
+// exist in the organization
+func (t TaskDeps) TaskBoard(ctx context.Context, orgID int, taskID string) (int, error) {
+	ctx, span := tracer.Start(ctx, "TaskDeps.TaskBoard")
+	defer span.End()
+
+	var boardID int
+	err := t.db.QueryRowContext(ctx, `
+		SELECT t.board_id FROM tasks t
+		JOIN board_config b ON b.id = t.board_id
+		WHERE t.id = $1 AND b.org_id = $2
+	`, taskID, orgID).Scan(&boardID)
+	return boardID, notFound(err, ErrTaskNotFound)
+}
+
+func (t TaskDeps) GetTask(ctx context.Context, orgID int, taskID string, task *models.Task) error {
+	ctx, span := tracer.Start(ctx, "TaskDeps.GetTask")
+	defer span.End()
 
+	err := t.db.QueryRowContext(ctx, `
+		SELECT t.id, t.board_id, t.title, t.description, t.state, t.priority, t.labels, t.version, t.created_at, t.updated_at
+		FROM tasks t
+		JOIN board_config b ON b.id = t.board_id
+		WHERE t.id = $1 AND b.org_id = $2
+	`, taskID, orgID).Scan(&task.ID, &task.BoardID, &task.Title, &task.Description, &task.State, &task.Priority,
+		pq.Array(&task.Labels), &task.Version, &task.CreatedAt, &task.UpdatedAt)
 	if err != nil {
-		return err
+		return notFound(err, ErrTaskNotFound)
 	}
 
-	if assignee.Valid {
-		task.Assignee = assignee.String
+	if err = fillAssignment(ctx, t.db, task); err != nil {
+		return err
 	}
 
 	// Get comments for the task
-	commentRows, err := t.db.Query(`
-		SELECT c.id, c.content, u.username as author, c.created_at
+	commentRows, err := t.db.QueryContext(ctx, `
+		SELECT c.id, c.content, COALESCE(u.username, $2) as author, c.created_at
 		FROM comments c
-		JOIN users u ON c.author = u.id
+		LEFT JOIN users u ON c.author = u.id
 		WHERE c.task_id = $1
 		ORDER BY c.created_at DESC
-	`, taskID)
+	`, taskID, models.DeletedUserName)
 	if err != nil {
 		return err
 	}
@@ -105,11 +147,93 @@ func (t TaskDeps) GetTask(taskID string, task *models.Task) error {
 	return nil
 }
 
-func (t TaskDeps) UpdateTask(taskID string, updates map[string]interface{}) (*models.Task, error) {
-	// Get the current task state and assignee before update
-	var oldState string
-	var assigneeID sql.NullString
-	err := t.db.QueryRow("SELECT state, assignee FROM tasks WHERE id = $1", taskID).Scan(&oldState, &assigneeID)
+// checkState reports a state that isn't a column of the board
+func checkState(ctx context.Context, q querier, boardID int, state string) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM board_columns WHERE board_id = $1 AND id = $2)", boardID, state).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ValidationError(map[string]string{"state": "unknown column"})
+	}
+	return nil
+}
+
+// lockTask locks the task and its board for a change of the transaction and
+// bumps both versions. It returns the board, the state and the version of
+// the task before the change. The board is locked first like the board
+// changes do, which also lock the tasks of the board
+func lockTask(ctx context.Context, tx *sql.Tx, orgID int, taskID string) (boardID int, state string, version int, err error) {
+	err = tx.QueryRowContext(ctx, `
+		SELECT t.board_id FROM tasks t
+		JOIN board_config b ON b.id = t.board_id
+		WHERE t.id = $1 AND b.org_id = $2
+	`, taskID, orgID).Scan(&boardID)
+	if err != nil {
+		return 0, "", 0, notFound(err, ErrTaskNotFound)
+	}
+	if _, err = lockBoard(ctx, tx, orgID, boardID); err != nil {
+		return 0, "", 0, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE tasks SET version = version + 1 WHERE id = $1
+		RETURNING state, version - 1
+	`, taskID).Scan(&state, &version)
+	if err != nil {
+		return 0, "", 0, notFound(err, ErrTaskNotFound)
+	}
+	return boardID, state, version, nil
+}
+
+// touchUserTasks bumps the versions of the tasks a user is assigned to or
+// commented on and of their boards, before the user is deleted or erased.
+// Boards are locked before their tasks like in lockTask
+func touchUserTasks(ctx context.Context, tx *sql.Tx, userID string) error {
+	const userTasks = `
+		EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = $1)
+		OR EXISTS (SELECT 1 FROM comments c WHERE c.task_id = t.id AND c.author = $1)
+	`
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE board_config SET version = version + 1, updated_at = NOW()
+		WHERE id IN (SELECT t.board_id FROM tasks t WHERE `+userTasks+`)
+	`, userID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE tasks t SET version = version + 1 WHERE "+userTasks, userID)
+	return err
+}
+
+// UpdateTask changes the fields present in the update, see
+// models.UpdateTaskRequest. With ifVersion the task must still be at one of
+// these versions
+func (t TaskDeps) UpdateTask(ctx context.Context, orgID int, taskID string, update models.UpdateTaskRequest, ifVersion []int) (*models.Task, error) {
+	ctx, span := tracer.Start(ctx, "TaskDeps.UpdateTask")
+	defer span.End()
+
+	assigneeIDs, setAssignees := update.AssigneeUpdate()
+	groupID, setGroup := update.GroupUpdate()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Get the current task state and the users to notify before update
+	boardID, oldState, version, err := lockTask(ctx, tx, orgID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if err = matchVersion(version, ifVersion); err != nil {
+		return nil, err
+	}
+	if update.State != nil && *update.State != oldState {
+		if err = checkState(ctx, tx, boardID, *update.State); err != nil {
+			return nil, err
+		}
+	}
+	oldRecipients, err := taskRecipients(ctx, tx, taskID)
 	if err != nil {
 		return nil, err
 	}
@@ -119,123 +243,162 @@ func (t TaskDeps) UpdateTask(taskID string, updates map[string]interface{}) (*mo
 	params := []interface{}{}
 	paramCount := 1
 
-	if state, ok := updates["state"].(string); ok {
+	var state string
+	setState := update.State != nil
+	if setState {
+		state = *update.State
 		query += fmt.Sprintf(", state = $%d", paramCount)
 		params = append(params, state)
 		paramCount++
-
-		// If state has changed, create a notification for the assignee
-		if state != oldState && assigneeID.Valid {
-			_, err = t.db.Exec(`
-				INSERT INTO notifications (user_id, message, read, created_at)
-				VALUES ($1, $2, false, $3)
-			`, assigneeID.String, fmt.Sprintf("Статус вашей задачи изменен на: %s", state), time.Now())
-
-			if err != nil {
-				log.Printf("Error creating notification: %v", err)
-			}
-		}
 	}
 
-	if title, ok := updates["title"].(string); ok {
+	if update.Title != nil {
 		query += fmt.Sprintf(", title = $%d", paramCount)
-		params = append(params, title)
+		params = append(params, strings.TrimSpace(*update.Title))
 		paramCount++
 	}
 
-	if description, ok := updates["description"].(string); ok {
+	if update.Description != nil {
 		query += fmt.Sprintf(", description = $%d", paramCount)
-		params = append(params, description)
+		params = append(params, *update.Description)
 		paramCount++
 	}
 
-	if priority, ok := updates["priority"].(float64); ok {
+	var priority int
+	setPriority := update.Priority != nil
+	if setPriority {
+		priority = *update.Priority
 		query += fmt.Sprintf(", priority = $%d", paramCount)
-		params = append(params, int(priority))
+		params = append(params, priority)
 		paramCount++
-		// Priority change notification to assignee
-		if assigneeID.Valid {
-			var taskTitle string
-			_ = t.db.QueryRow("SELECT title FROM tasks WHERE id = $1", taskID).Scan(&taskTitle)
-			if _, err = t.db.Exec(`
-                INSERT INTO notifications (user_id, message, read, created_at)
-                VALUES ($1, $2, false, $3)
-            `, assigneeID.String, fmt.Sprintf("Приоритет задачи '%s' изменен на %d", taskTitle, int(priority)), time.Now()); err != nil {
-				log.Printf("Error creating notification: %v", err)
-			}
-		}
 	}
 
-	if assignee, ok := updates["assignee"].(string); ok {
-		query += fmt.Sprintf(", assignee = $%d", paramCount)
-		params = append(params, assignee)
+	if setGroup {
+		query += fmt.Sprintf(", group_id = $%d", paramCount)
+		params = append(params, nullString(groupID))
 		paramCount++
+	}
 
-		// If assignee has changed, create a notification for the new assignee
-		if assignee != "" && (!assigneeID.Valid || assignee != assigneeID.String) {
-			var taskTitle string
-			err = t.db.QueryRow("SELECT title FROM tasks WHERE id = $1", taskID).Scan(&taskTitle)
-			if err == nil {
-				_, err = t.db.Exec(`
-					INSERT INTO notifications (user_id, message, read, created_at)
-					VALUES ($1, $2, false, $3)
-				`, assignee, fmt.Sprintf("Вам назначена задача: %s", taskTitle), time.Now())
-
-				if err != nil {
-					log.Printf("Error creating notification: %v", err)
-				}
-			}
+	if labels, setLabels := update.LabelUpdate(); setLabels {
+		if labels == nil {
+			labels = []string{}
 		}
+		query += fmt.Sprintf(", labels = $%d", paramCount)
+		params = append(params, pq.Array(labels))
+		paramCount++
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d RETURNING id, title, description, state, priority, assignee, created_at, updated_at", paramCount)
+	query += fmt.Sprintf(" WHERE id = $%d RETURNING id, board_id, title, description, state, priority, labels, version, created_at, updated_at", paramCount)
 	params = append(params, taskID)
 
 	var task models.Task
-	var newAssigneeID sql.NullString
-	err = t.db.QueryRow(query, params...).Scan(&task.ID, &task.Title, &task.Description, &task.State, &task.Priority, &newAssigneeID, &task.CreatedAt, &task.UpdatedAt)
+	err = tx.QueryRowContext(ctx, query, params...).Scan(&task.ID, &task.BoardID, &task.Title, &task.Description, &task.State, &task.Priority,
+		pq.Array(&task.Labels), &task.Version, &task.CreatedAt, &task.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get assignee username if assignee ID exists
-	if newAssigneeID.Valid {
-		var username string
-		err = t.db.QueryRow("SELECT username FROM users WHERE id = $1", newAssigneeID.String).Scan(&username)
-		if err == nil {
-			task.Assignee = username
+	if setAssignees {
+		if err = replaceTaskAssignees(ctx, tx, taskID, assigneeIDs); err != nil {
+			return nil, err
+		}
+	}
+	if err = fillAssignment(ctx, tx, &task); err != nil {
+		return nil, err
+	}
+	recipients, err := taskRecipients(ctx, tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	// A task is completed when it moves into the last column of its board
+	var lastColumn string
+	if setState && state != oldState {
+		err = tx.QueryRowContext(ctx, "SELECT COALESCE(column_order->>-1, '') FROM board_config WHERE id = $1", task.BoardID).Scan(&lastColumn)
+		if err != nil {
+			return nil, err
 		}
 	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if lastColumn != "" && state == lastColumn {
+		metrics.TasksCompleted.Inc()
+	}
+
+	// If state has changed, notify the assignees and the group
+	if setState && state != oldState {
+		t.notifyUsers(ctx, recipients, fmt.Sprintf("Статус вашей задачи изменен на: %s", state))
+	}
+	if setPriority {
+		t.notifyUsers(ctx, recipients, fmt.Sprintf("Приоритет задачи '%s' изменен на %d", task.Title, priority))
+	}
+	// Users who just got the task, directly or through the group
+	if setAssignees || setGroup {
+		t.notifyUsers(ctx, newRecipients(oldRecipients, recipients), fmt.Sprintf("Вам назначена задача: %s", task.Title))
+	}
 	return &task, nil
 }
 
-func (t TaskDeps) DeleteTask(taskID string) error {
-	// Notify assignee before delete
-	var assigneeID sql.NullString
+// DeleteTask deletes a task of the organization. With ifVersion the task must
+// still be at one of these versions
+func (t TaskDeps) DeleteTask(ctx context.Context, orgID int, taskID string, ifVersion []int) error {
+	ctx, span := tracer.Start(ctx, "TaskDeps.DeleteTask")
+	defer span.End()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, _, version, err := lockTask(ctx, tx, orgID, taskID)
+	if err != nil {
+		return err
+	}
+	if err = matchVersion(version, ifVersion); err != nil {
+		return err
+	}
+
+	// Notify assignees before delete
 	var title string
-	_ = t.db.QueryRow("SELECT assignee, title FROM tasks WHERE id = $1", taskID).Scan(&assigneeID, &title)
+	if err = tx.QueryRowContext(ctx, "SELECT title FROM tasks WHERE id = $1", taskID).Scan(&title); err != nil {
+		return err
+	}
+	recipients, err := taskRecipients(ctx, tx, taskID)
+	if err != nil {
+		return err
+	}
 
 	// Delete task from database
-	_, err := t.db.Exec("DELETE FROM tasks WHERE id = $1", taskID)
-	if err != nil {
+	if _, err = tx.ExecContext(ctx, "DELETE FROM tasks WHERE id = $1", taskID); err != nil {
 		return err
 	}
-	if assigneeID.Valid {
-		if _, nerr := t.db.Exec(`
-            INSERT INTO notifications (user_id, message, read, created_at)
-            VALUES ($1, $2, false, $3)
-        `, assigneeID.String, fmt.Sprintf("Задача '%s' была удалена", title), time.Now()); nerr != nil {
-			log.Printf("Error creating notification: %v", nerr)
-		}
+	if err = tx.Commit(); err != nil {
+		return err
 	}
+	t.notifyUsers(ctx, recipients, fmt.Sprintf("Задача '%s' была удалена", title))
 	return nil
 }
 
-func (t TaskDeps) AddComment(userID string, taskID string, content string) (*models.Comment, error) {
+func (t TaskDeps) AddComment(ctx context.Context, orgID int, userID string, taskID string, content string) (*models.Comment, error) {
+	ctx, span := tracer.Start(ctx, "TaskDeps.AddComment")
+	defer span.End()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Comments are part of the task and of the board, both get a new version
+	if _, _, _, err = lockTask(ctx, tx, orgID, taskID); err != nil {
+		return nil, err
+	}
+
 	// Insert comment
 	var comment models.Comment
 	now := time.Now()
-	err := t.db.QueryRow(`
+	err = tx.QueryRowContext(ctx, `
         INSERT INTO comments (task_id, content, author, created_at)
         VALUES ($1, $2, $3, $4)
         RETURNING id, content, created_at
@@ -243,26 +406,169 @@ func (t TaskDeps) AddComment(userID string, taskID string, content string) (*mod
 	if err != nil {
 		return nil, err
 	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
 
 	// Load author username
 	var authorUsername string
-	if err = t.db.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&authorUsername); err == nil {
+	if err = t.db.QueryRowContext(ctx, "SELECT username FROM users WHERE id = $1", userID).Scan(&authorUsername); err == nil {
 		comment.Author = authorUsername
 	}
 
-	// Notify assignee of the task
-	var assigneeID sql.NullString
+	// Notify assignees and the group of the task
 	var title string
-	if err = t.db.QueryRow("SELECT assignee, title FROM tasks WHERE id = $1", taskID).Scan(&assigneeID, &title); err == nil {
-		if assigneeID.Valid {
-			if _, nerr := t.db.Exec(`
-                INSERT INTO notifications (user_id, message, read, created_at)
-                VALUES ($1, $2, false, $3)
-            `, assigneeID.String, fmt.Sprintf("К задаче '%s' добавлен комментарий", title), time.Now()); nerr != nil {
-				log.Printf("Error creating notification: %v", nerr)
-			}
+	if err = t.db.QueryRowContext(ctx, "SELECT title FROM tasks WHERE id = $1", taskID).Scan(&title); err == nil {
+		recipients, err := taskRecipients(ctx, t.db, taskID)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error loading task recipients", "task_id", taskID, "err", err)
 		}
+		t.notifyUsers(ctx, recipients, fmt.Sprintf("К задаче '%s' добавлен комментарий", title))
 	}
 
 	return &comment, nil
 }
+
+// replaceTaskAssignees sets the assignees of a task, keeping the assignment
+// time of the users that stay
+func replaceTaskAssignees(ctx context.Context, tx *sql.Tx, taskID string, userIDs []string) error {
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM task_assignees WHERE task_id = $1 AND NOT (user_id = ANY($2::uuid[]))
+	`, taskID, pq.Array(userIDs)); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO task_assignees (task_id, user_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT (task_id, user_id) DO NOTHING
+	`, taskID, pq.Array(userIDs))
+	return err
+}
+
+// taskAssignees loads the assignees of the tasks matching the filter on
+// tasks t, by task
+func taskAssignees(ctx context.Context, q querier, filter string, args ...interface{}) (map[string][]models.TaskAssignee, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT a.task_id, u.id, u.username
+		FROM task_assignees a
+		JOIN tasks t ON t.id = a.task_id
+		JOIN users u ON u.id = a.user_id
+		`+filter+`
+		ORDER BY a.created_at, u.username
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignees := make(map[string][]models.TaskAssignee)
+	for rows.Next() {
+		var taskID string
+		var assignee models.TaskAssignee
+		if err := rows.Scan(&taskID, &assignee.ID, &assignee.Username); err != nil {
+			return nil, err
+		}
+		assignees[taskID] = append(assignees[taskID], assignee)
+	}
+	return assignees, rows.Err()
+}
+
+// setAssignees sets the assignees of the task, the first one is also
+// reported as Assignee
+func setAssignees(task *models.Task, assignees []models.TaskAssignee) {
+	task.Assignees = assignees
+	if task.Assignees == nil {
+		task.Assignees = []models.TaskAssignee{}
+	}
+	task.Assignee = ""
+	if len(task.Assignees) > 0 {
+		task.Assignee = task.Assignees[0].Username
+	}
+}
+
+// fillAssignment loads the assignees and the group of the task
+func fillAssignment(ctx context.Context, q querier, task *models.Task) error {
+	assignees, err := taskAssignees(ctx, q, "WHERE t.id = $1", task.ID)
+	if err != nil {
+		return err
+	}
+	setAssignees(task, assignees[task.ID])
+
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE(g.id::text, ''), COALESCE(g.name, '')
+		FROM tasks t
+		LEFT JOIN groups g ON g.id = t.group_id
+		WHERE t.id = $1
+	`, task.ID).Scan(&task.GroupID, &task.Group)
+	return err
+}
+
+// taskRecipients returns the users notified about a task: its assignees and
+// the members of its group who have access to the board
+func taskRecipients(ctx context.Context, q querier, taskID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT user_id FROM task_assignees WHERE task_id = $1
+		UNION
+		SELECT gm.user_id
+		FROM tasks t
+		JOIN group_members gm ON gm.group_id = t.group_id
+		JOIN board_members bm ON bm.board_id = t.board_id AND bm.user_id = gm.user_id
+		WHERE t.id = $1
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// newRecipients returns the users of after that are not in before
+func newRecipients(before, after []string) []string {
+	known := make(map[string]bool, len(before))
+	for _, id := range before {
+		known[id] = true
+	}
+	var added []string
+	for _, id := range after {
+		if !known[id] {
+			added = append(added, id)
+		}
+	}
+	return added
+}
+
+// notifyUsers creates the same notification for every user, errors are only
+// logged
+func (t TaskDeps) notifyUsers(ctx context.Context, userIDs []string, message string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	if _, err := t.db.ExecContext(ctx, `
+		INSERT INTO notifications (user_id, message, read, created_at)
+		SELECT unnest($1::uuid[]), $2, false, $3
+	`, pq.Array(userIDs), message, time.Now()); err != nil {
+		logging.FromContext(ctx).Error("Error creating notification", "err", err)
+		return
+	}
+	metrics.NotificationsSent.Add(float64(len(userIDs)))
+}
+
+// nullString maps an empty string to NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultTokenLifetimeDays = 90
+	maxTokenLifetimeDays     = 365
+)
+
+var (
+	ErrInvalidToken  = newError(KindUnauthorized, "invalid_token", "invalid or expired token")
+	ErrInvalidScope  = newError(KindValidation, "invalid_token_scope", "invalid token scope")
+	ErrTokenNotFound = newError(KindNotFound, "token_not_found", "token not found")
+)
+
+type TokenDeps struct {
+	db *sql.DB
+}
+
+func NewTokenDeps(db *sql.DB) *TokenDeps {
+	return &TokenDeps{
+		db: db,
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func validScope(scope string) bool {
+	return scope == models.ScopeRead || scope == models.ScopeWrite || scope == models.ScopeAdmin
+}
+
+func (t TokenDeps) CreateToken(ctx context.Context, userID string, req models.CreateTokenRequest) (*models.CreateTokenResponse, error) {
+	ctx, span := tracer.Start(ctx, "TokenDeps.CreateToken")
+	defer span.End()
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{models.ScopeRead}
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	days := req.ExpiresInDays
+	if days <= 0 {
+		days = defaultTokenLifetimeDays
+	}
+	if days > maxTokenLifetimeDays {
+		days = maxTokenLifetimeDays
+	}
+
+	// Generate a random token, only its hash is persisted
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	plain := models.TokenPrefix + hex.EncodeToString(raw)
+
+	token := models.APIToken{
+		UserID: userID,
+		Name:   req.Name,
+		Prefix: plain[:len(models.TokenPrefix)+8],
+		Scopes: req.Scopes,
+	}
+	err := t.db.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, expires_at, created_at
+	`, userID, req.Name, hashToken(plain), token.Prefix, pq.Array(req.Scopes), time.Now().AddDate(0, 0, days)).
+		Scan(&token.ID, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CreateTokenResponse{Token: plain, APIToken: token}, nil
+}
+
+func (t TokenDeps) GetTokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	ctx, span := tracer.Start(ctx, "TokenDeps.GetTokens")
+	defer span.End()
+
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var token models.APIToken
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, pq.Array(&token.Scopes),
+			&token.ExpiresAt, &lastUsed, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			token.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (t TokenDeps) RevokeToken(ctx context.Context, userID string, tokenID string) error {
+	ctx, span := tracer.Start(ctx, "TokenDeps.RevokeToken")
+	defer span.End()
+
+	if !isUUID(tokenID) {
+		return ErrTokenNotFound
+	}
+	result, err := t.db.ExecContext(ctx, `
+		UPDATE api_tokens
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateToken resolves a plain personal access token to claims and
+// records when it was last used
+func (t TokenDeps) AuthenticateToken(ctx context.Context, token string) (*models.Claims, error) {
+	ctx, span := tracer.Start(ctx, "TokenDeps.AuthenticateToken")
+	defer span.End()
+
+	claims := &models.Claims{}
+	err := t.db.QueryRowContext(ctx, `
+		UPDATE api_tokens a
+		SET last_used_at = NOW()
+		FROM users u
+		WHERE a.user_id = u.id AND a.token_hash = $1 AND u.deactivated_at IS NULL
+		  AND a.revoked_at IS NULL AND a.expires_at > NOW()
+		RETURNING a.id, u.id, u.role, u.org_id, u.is_super_admin, a.scopes
+	`, hashToken(token)).Scan(&claims.TokenID, &claims.UserID, &claims.Role, &claims.OrgID, &claims.SuperAdmin, pq.Array(&claims.Scopes))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
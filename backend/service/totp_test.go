@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes, the 6-digit code is their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	code, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 59/totpPeriod)
+	if err != nil || code != "287082" {
+		t.Errorf("totpCode with lowercase secret = %q, %v", code, err)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name string
+		step int64
+		ok   bool
+	}{
+		{"current step", current, true},
+		{"previous step", current - 1, true},
+		{"next step", current + 1, true},
+		{"two steps back", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := totpCode(rfc6238Secret, tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := validateTOTP(rfc6238Secret, code, 0, now)
+			if ok != tt.ok {
+				t.Fatalf("validateTOTP ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != tt.step {
+				t.Errorf("validateTOTP step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code, err := totpCode(rfc6238Secret, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := validateTOTP(rfc6238Secret, code, 0, now)
+	if !ok || step != current {
+		t.Fatalf("first use: step %d, ok %v", step, ok)
+	}
+	// totp_last_step is now the used step, the same code is refused
+	if _, ok := validateTOTP(rfc6238Secret, code, step, now); ok {
+		t.Error("code accepted again after its step was used")
+	}
+	// A code of an older step within the window is refused as well
+	previous, _ := totpCode(rfc6238Secret, current-1)
+	if _, ok := validateTOTP(rfc6238Secret, previous, step, now); ok {
+		t.Error("code of an older step accepted after a newer one was used")
+	}
+	// The next step still works
+	next, _ := totpCode(rfc6238Secret, current+1)
+	if got, ok := validateTOTP(rfc6238Secret, next, step, now); !ok || got != current+1 {
+		t.Errorf("next step: step %d, ok %v", got, ok)
+	}
+}
+
+func TestValidateTOTPFormat(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := totpCode(rfc6238Secret, now.Unix()/totpPeriod)
+
+	if _, ok := validateTOTP(rfc6238Secret, code[:3]+" "+code[3:], 0, now); !ok {
+		t.Error("code with a space refused")
+	}
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := validateTOTP(rfc6238Secret, bad, 0, now); ok {
+			t.Errorf("validateTOTP accepted %q", bad)
+		}
+	}
+}
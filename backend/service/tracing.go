@@ -0,0 +1,7 @@
+package service
+
+import "go.opentelemetry.io/otel"
+
+// tracer records a span for every exported service method, the SQL statements
+// they run become its children
+var tracer = otel.Tracer("belykh-ik/taskflow/service")
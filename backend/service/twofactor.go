@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"belykh-ik/taskflow/models"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrInvalidTwoFactorCode = newError(KindValidation, "invalid_2fa_code", "invalid two-factor code")
+	ErrTwoFactorNotPending  = newError(KindValidation, "2fa_not_pending", "two-factor enrollment was not started")
+	ErrTwoFactorEnabled     = newError(KindConflict, "2fa_enabled", "two-factor authentication is already enabled")
+	ErrTwoFactorDisabled    = newError(KindConflict, "2fa_disabled", "two-factor authentication is not enabled")
+	ErrTwoFactorRequired    = newError(KindForbidden, "2fa_required", "two-factor authentication is required for this account")
+)
+
+type TwoFactorDeps struct {
+	db *sql.DB
+}
+
+func NewTwoFactorDeps(db *sql.DB) *TwoFactorDeps {
+	return &TwoFactorDeps{
+		db: db,
+	}
+}
+
+func (t TwoFactorDeps) GetStatus(ctx context.Context, userID string) (*models.TwoFactorStatus, error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.GetStatus")
+	defer span.End()
+
+	var status models.TwoFactorStatus
+	err := t.db.QueryRowContext(ctx, `
+		SELECT u.totp_enabled, u.totp_required OR COALESCE((o.settings->>'requireTwoFactor')::boolean, false),
+		       (SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL)
+		FROM users u JOIN organizations o ON o.id = u.org_id
+		WHERE u.id = $1
+	`, userID).Scan(&status.Enabled, &status.Required, &status.RecoveryCodesLeft)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// BeginEnrollment stores a new pending secret and returns it together with the
+// otpauth URI for authenticator apps
+func (t TwoFactorDeps) BeginEnrollment(ctx context.Context, userID string) (*models.TwoFactorEnrollment, error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.BeginEnrollment")
+	defer span.End()
+
+	var email string
+	var enabled bool
+	if err := t.db.QueryRowContext(ctx, "SELECT email, totp_enabled FROM users WHERE id = $1", userID).Scan(&email, &enabled); err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = t.db.ExecContext(ctx, "UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2", secret, userID); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totpURI(secret, email),
+	}, nil
+}
+
+// ConfirmEnrollment enables two-factor authentication once the user proves
+// the authenticator app works, and returns fresh recovery codes
+func (t TwoFactorDeps) ConfirmEnrollment(ctx context.Context, userID, code string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.ConfirmEnrollment")
+	defer span.End()
+
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err := t.db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1", userID).
+		Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+
+	step, ok := validateTOTP(secret.String, code, lastStep, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Verify accepts either a TOTP code or an unused recovery code
+func (t TwoFactorDeps) Verify(ctx context.Context, userID, code, recoveryCode string) error {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.Verify")
+	defer span.End()
+
+	if recoveryCode != "" {
+		result, err := t.db.ExecContext(ctx, `
+			UPDATE recovery_codes SET used_at = NOW()
+			WHERE id = (
+				SELECT id FROM recovery_codes
+				WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+				LIMIT 1
+			)
+		`, userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err := t.db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1", userID).
+		Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return err
+	}
+	if !enabled || !secret.Valid {
+		return ErrTwoFactorDisabled
+	}
+
+	step, ok := validateTOTP(secret.String, code, lastStep, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// Only one request can consume a given step
+	result, err := t.db.ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// Disable turns two-factor authentication off for the user, it is refused
+// while an admin or the organization requires it
+func (t TwoFactorDeps) Disable(ctx context.Context, userID string) error {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.Disable")
+	defer span.End()
+
+	status, err := t.GetStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if status.Required {
+		return ErrTwoFactorRequired
+	}
+	return t.reset(ctx, userID)
+}
+
+// Reset removes the secret and recovery codes regardless of the requirement,
+// used by admins of the organization when a user lost their device
+func (t TwoFactorDeps) Reset(ctx context.Context, orgID int, userID string) error {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.Reset")
+	defer span.End()
+
+	var exists bool
+	if err := t.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND org_id = $2)", userID, orgID).Scan(&exists); err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return t.reset(ctx, userID)
+}
+
+func (t TwoFactorDeps) reset(ctx context.Context, userID string) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0
+		WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t TwoFactorDeps) RegenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.RegenerateRecoveryCodes")
+	defer span.End()
+
+	status, err := t.GetStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Enabled {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+func (t TwoFactorDeps) SetRequired(ctx context.Context, orgID int, userID string, required bool) error {
+	ctx, span := tracer.Start(ctx, "TwoFactorDeps.SetRequired")
+	defer span.End()
+
+	result, err := t.db.ExecContext(ctx, "UPDATE users SET totp_required = $1 WHERE id = $2 AND org_id = $3", required, userID, orgID)
+	if err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(b)
+		code := raw[:5] + "-" + raw[5:]
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
@@ -1,10 +1,21 @@
 package service
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"time"
 
+	"belykh-ik/taskflow/metrics"
 	"belykh-ik/taskflow/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidReassignTarget = newError(KindValidation, "invalid_reassign_target", "tasks can only be reassigned to another active user of the organization")
+	ErrLastSuperAdmin        = newError(KindConflict, "last_super_admin", "the last super-admin can't be removed")
+	ErrSoleBoardOwner        = newError(KindConflict, "sole_board_owner", "the user is the only owner of a board, pass reassignTo to hand it over")
 )
 
 type UserDeps struct {
@@ -17,8 +28,11 @@ func NewUserDeps(db *sql.DB) *UserDeps {
 	}
 }
 
-func (u UserDeps) GetUsers() ([]models.User, error) {
-	rows, err := u.db.Query("SELECT id, username, email, role, created_at FROM users")
+func (u UserDeps) GetUsers(ctx context.Context, orgID int) ([]models.User, error) {
+	ctx, span := tracer.Start(ctx, "UserDeps.GetUsers")
+	defer span.End()
+
+	rows, err := u.db.QueryContext(ctx, "SELECT id, username, email, role, org_id, is_super_admin, deactivated_at IS NULL, created_at FROM users WHERE org_id = $1 AND erased_at IS NULL", orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -27,7 +41,7 @@ func (u UserDeps) GetUsers() ([]models.User, error) {
 	users := []models.User{}
 	for rows.Next() {
 		var user models.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.Active, &user.CreatedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -35,32 +49,246 @@ func (u UserDeps) GetUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func (u UserDeps) CreateUser(username, email, password, role string) (*models.User, error) {
+// GetUser returns a user of the organization
+func (u UserDeps) GetUser(ctx context.Context, orgID int, userID string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "UserDeps.GetUser")
+	defer span.End()
+
+	var user models.User
+	err := u.db.QueryRowContext(ctx, `
+		SELECT id, username, email, role, org_id, is_super_admin, deactivated_at IS NULL, created_at
+		FROM users WHERE id = $1 AND org_id = $2 AND erased_at IS NULL
+	`, userID, orgID).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.SuperAdmin, &user.Active, &user.CreatedAt)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+	return &user, nil
+}
+
+// CreateUser adds an account to the organization on behalf of an admin, the
+// admin vouches for the email so it doesn't go through verification
+func (u UserDeps) CreateUser(ctx context.Context, orgID int, username, email, password, role string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "UserDeps.CreateUser")
+	defer span.End()
+
 	var user models.User
 	now := time.Now()
-	err := u.db.QueryRow(`
-        INSERT INTO users (username, email, password, role, created_at)
-        VALUES ($1, $2, $3, $4, $5)
-        RETURNING id, username, email, role, created_at
-    `, username, email, password, role, now).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt)
+	err := u.db.QueryRowContext(ctx, `
+        INSERT INTO users (org_id, username, email, password, role, email_verified, created_at)
+        VALUES ($1, $2, $3, $4, $5, true, $6)
+        RETURNING id, username, email, role, org_id, email_verified, created_at
+    `, orgID, username, email, password, role, now).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.OrgID, &user.EmailVerified, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
+	user.Active = true
 	return &user, nil
 }
 
-func (u UserDeps) DeleteUser(userID string) error {
-	// Reassign tasks: set assignee NULL and move to backlog
-	if _, err := u.db.Exec(`
-        UPDATE tasks SET assignee = NULL, state = 'backlog', updated_at = NOW()
-        WHERE assignee = $1
-    `, userID); err != nil {
+// UpdateRole changes the role of a user of the organization
+func (u UserDeps) UpdateRole(ctx context.Context, orgID int, userID, role string) error {
+	ctx, span := tracer.Start(ctx, "UserDeps.UpdateRole")
+	defer span.End()
+
+	result, err := u.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2 AND org_id = $3", role, userID, orgID)
+	if err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetActive deactivates or reactivates a user of the organization.
+// Deactivated users can't log in, their history stays in place. The last
+// super-admin can't be deactivated
+func (u UserDeps) SetActive(ctx context.Context, orgID int, userID string, active bool) error {
+	ctx, span := tracer.Start(ctx, "UserDeps.SetActive")
+	defer span.End()
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !active {
+		if err = keepSuperAdmin(ctx, tx, userID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET deactivated_at = CASE WHEN $1 THEN NULL ELSE COALESCE(deactivated_at, NOW()) END
+		WHERE id = $2 AND org_id = $3 AND erased_at IS NULL
+	`, active, userID, orgID)
+	if err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+	return tx.Commit()
+}
+
+// keepSuperAdmin refuses to remove the last active super-admin. The active
+// super-admins are locked so two removals can't both see the other one left
+func keepSuperAdmin(ctx context.Context, tx *sql.Tx, userID string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM users WHERE is_super_admin AND deactivated_at IS NULL FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	target, others := false, false
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if id == userID {
+			target = true
+		} else {
+			others = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if target && !others {
+		return ErrLastSuperAdmin
+	}
+	return nil
+}
+
+// IsActive reports whether the user exists and isn't deactivated
+func (u UserDeps) IsActive(ctx context.Context, userID string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "UserDeps.IsActive")
+	defer span.End()
+
+	var active bool
+	err := u.db.QueryRowContext(ctx, "SELECT deactivated_at IS NULL FROM users WHERE id = $1", userID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return active, err
+}
+
+// DeleteUser deletes a user of the organization. Its tasks go to the
+// reassignment target on the boards the target is a member of, and to the
+// backlog unless KeepState is set. Comments stay with a placeholder author.
+// The last super-admin can't be deleted, and neither can the only owner of a
+// board unless the target takes the board over
+func (u UserDeps) DeleteUser(ctx context.Context, orgID int, userID string, opts models.DeleteUserOptions) error {
+	ctx, span := tracer.Start(ctx, "UserDeps.DeleteUser")
+	defer span.End()
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var username string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = $1 AND org_id = $2 FOR UPDATE", userID, orgID).Scan(&username); err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if err := keepSuperAdmin(ctx, tx, userID); err != nil {
+		return err
+	}
+	if opts.ReassignTo == "" {
+		var soleOwner bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM board_members m
+				WHERE m.user_id = $1 AND m.role = $2 AND NOT EXISTS (
+					SELECT 1 FROM board_members o WHERE o.board_id = m.board_id AND o.role = $2 AND o.user_id <> $1
+				)
+			)
+		`, userID, models.BoardRoleOwner).Scan(&soleOwner)
+		if err != nil {
+			return err
+		}
+		if soleOwner {
+			return ErrSoleBoardOwner
+		}
+	}
+	if err := touchUserTasks(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if opts.ReassignTo != "" {
+		var active bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT deactivated_at IS NULL FROM users WHERE id = $1 AND org_id = $2 AND id <> $3
+		`, opts.ReassignTo, orgID, userID).Scan(&active)
+		if pqErr, ok := err.(*pq.Error); err == sql.ErrNoRows || (ok && pqErr.Code == "22P02") || (err == nil && !active) {
+			return ErrInvalidReassignTarget
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.KeepState {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE tasks t SET state = 'backlog', updated_at = NOW()
+			WHERE EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = $1)
+		`, userID); err != nil {
+			return err
+		}
+	}
+
+	var reassigned int64
+	if opts.ReassignTo != "" {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO task_assignees (task_id, user_id)
+			SELECT a.task_id, $2
+			FROM task_assignees a
+			JOIN tasks t ON t.id = a.task_id
+			JOIN board_members bm ON bm.board_id = t.board_id AND bm.user_id = $2
+			WHERE a.user_id = $1
+			ON CONFLICT (task_id, user_id) DO NOTHING
+		`, userID, opts.ReassignTo)
+		if err != nil {
+			return err
+		}
+		if reassigned, err = result.RowsAffected(); err != nil {
+			return err
+		}
+
+		// Boards the user was the only owner of keep an owner
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO board_members (board_id, user_id, role)
+			SELECT m.board_id, $2, $3
+			FROM board_members m
+			WHERE m.user_id = $1 AND m.role = $3 AND NOT EXISTS (
+				SELECT 1 FROM board_members o WHERE o.board_id = m.board_id AND o.role = $3 AND o.user_id <> $1
+			)
+			ON CONFLICT (board_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		`, userID, opts.ReassignTo, models.BoardRoleOwner); err != nil {
+			return err
+		}
+	}
+
+	// Delete user, assignments and memberships go with it
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
 		return err
 	}
 
-	// Delete user
-	if _, err := u.db.Exec(`DELETE FROM users WHERE id = $1`, userID); err != nil {
+	if reassigned > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO notifications (user_id, message, read, created_at)
+			VALUES ($1, $2, false, $3)
+		`, opts.ReassignTo, fmt.Sprintf("Вам переданы задачи пользователя %s: %d", username, reassigned), time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	if reassigned > 0 {
+		metrics.NotificationsSent.Inc()
+	}
 	return nil
 }
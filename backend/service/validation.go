@@ -0,0 +1,176 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"belykh-ik/taskflow/models"
+)
+
+// Limits of the request fields, matching the column sizes of the schema
+const (
+	maxTitleLength       = 255
+	maxDescriptionLength = 10000
+	maxColumnIDLength    = 50
+	maxUsernameLength    = 255
+	maxEmailLength       = 255
+	maxPasswordLength    = 255
+	maxLabelLength       = 50
+	maxTaskLabels        = 20
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// fieldErrors collects the invalid fields of a request, the first problem of
+// each field is kept
+type fieldErrors map[string]string
+
+func (f fieldErrors) check(ok bool, field, message string) {
+	if _, seen := f[field]; !ok && !seen {
+		f[field] = message
+	}
+}
+
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return ValidationError(f)
+}
+
+func (f fieldErrors) text(value, field string, min, max int) {
+	length := utf8.RuneCountInString(strings.TrimSpace(value))
+	f.check(length >= min, field, "is required")
+	f.check(length <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+func (f fieldErrors) uuid(value, field string) {
+	f.check(isUUID(value), field, "must be a valid id")
+}
+
+func (f fieldErrors) priority(value int, field string) {
+	f.check(value >= models.PriorityHigh && value <= models.PriorityLow, field,
+		fmt.Sprintf("must be between %d and %d", models.PriorityHigh, models.PriorityLow))
+}
+
+// labels checks the labels of a task and returns them trimmed, without
+// duplicates
+func (f fieldErrors) labels(labels []string, field string) []string {
+	f.check(len(labels) <= maxTaskLabels, field, fmt.Sprintf("must have at most %d labels", maxTaskLabels))
+	cleaned := make([]string, 0, len(labels))
+	seen := make(map[string]bool, len(labels))
+	for i, label := range labels {
+		label = strings.TrimSpace(label)
+		f.text(label, fmt.Sprintf("%s.%d", field, i), 1, maxLabelLength)
+		if !seen[label] {
+			seen[label] = true
+			cleaned = append(cleaned, label)
+		}
+	}
+	return cleaned
+}
+
+func isUUID(value string) bool {
+	return uuidPattern.MatchString(value)
+}
+
+// validEmail accepts a bare address, without a display name
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email && len(email) <= maxEmailLength
+}
+
+// ValidateCreateTask checks a new task, the state is checked against the
+// columns of the board when the task is created
+func ValidateCreateTask(req *models.CreateTaskRequest) error {
+	f := fieldErrors{}
+	f.text(req.Title, "title", 1, maxTitleLength)
+	f.text(req.Description, "description", 0, maxDescriptionLength)
+	f.check(len(req.State) <= maxColumnIDLength, "state", "unknown column")
+	if req.Priority != nil {
+		f.priority(*req.Priority, "priority")
+	}
+	if req.Assignee != "" && req.Assignee != "null" {
+		f.uuid(req.Assignee, "assignee")
+	}
+	for i, id := range req.AssigneeIDs {
+		f.uuid(id, fmt.Sprintf("assigneeIds.%d", i))
+	}
+	if req.GroupID != "" {
+		f.uuid(req.GroupID, "groupId")
+	}
+	req.Labels = f.labels(req.Labels, "labels")
+	return f.err()
+}
+
+// ValidateUpdateTask checks the fields set by a task update
+func ValidateUpdateTask(req *models.UpdateTaskRequest) error {
+	f := fieldErrors{}
+	if req.Title != nil {
+		f.text(*req.Title, "title", 1, maxTitleLength)
+	}
+	if req.Description != nil {
+		f.text(*req.Description, "description", 0, maxDescriptionLength)
+	}
+	if req.State != nil {
+		f.check(*req.State != "" && len(*req.State) <= maxColumnIDLength, "state", "unknown column")
+	}
+	if req.Priority != nil {
+		f.priority(*req.Priority, "priority")
+	}
+	if req.Has("assignees") {
+		for i, id := range req.Assignees {
+			f.uuid(id, fmt.Sprintf("assignees.%d", i))
+		}
+	} else if assignees, ok := req.AssigneeUpdate(); ok && len(assignees) > 0 {
+		f.uuid(assignees[0], "assignee")
+	}
+	if req.GroupID != "" {
+		f.uuid(req.GroupID, "groupId")
+	}
+	if req.Has("labels") {
+		req.Labels = f.labels(req.Labels, "labels")
+	}
+	return f.err()
+}
+
+// ValidateNewUser checks the account fields of a user created by an admin
+func ValidateNewUser(username, email, password string) error {
+	f := fieldErrors{}
+	f.text(username, "username", 1, maxUsernameLength)
+	f.check(validEmail(email), "email", "must be a valid email address")
+	f.check(password != "", "password", "is required")
+	f.check(len(password) <= maxPasswordLength, "password", fmt.Sprintf("must be at most %d characters", maxPasswordLength))
+	return f.err()
+}
+
+// ValidateProfile checks the fields set by a profile update
+func ValidateProfile(req *models.UpdateProfileRequest) error {
+	f := fieldErrors{}
+	if req.Username != nil {
+		f.text(*req.Username, "username", 1, maxUsernameLength)
+	}
+	if req.Email != nil {
+		f.check(validEmail(*req.Email), "email", "must be a valid email address")
+	}
+	return f.err()
+}
+
+// ValidateColumns checks the columns of a board update, whether they exist is
+// checked when the board is updated
+func ValidateColumns(columns []ColumnUpdate) error {
+	f := fieldErrors{}
+	f.check(len(columns) > 0, "columns", "is required")
+	seen := make(map[string]bool, len(columns))
+	for i, col := range columns {
+		field := fmt.Sprintf("columns.%d", i)
+		f.check(col.ID != "" && len(col.ID) <= maxColumnIDLength, field+".id", "unknown column")
+		f.check(!seen[col.ID], field+".id", "duplicate column")
+		seen[col.ID] = true
+		f.text(col.Title, field+".title", 1, maxTitleLength)
+	}
+	return f.err()
+}
@@ -1,5 +1,5 @@
 import axios from 'axios';
-import { Board, Task, User, Notification, Comment } from '../types';
+import { Board, BoardMember, Task, User, Notification, Comment } from '../types';
 
 // API URL from environment variable or default
 const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080/api';
@@ -48,6 +48,17 @@ export const fetchBoardData = async (): Promise<Board> => {
   }
 };
 
+// Board members are the users tasks can be assigned to
+export const fetchBoardMembers = async (boardId = 1): Promise<BoardMember[]> => {
+  try {
+    const response = await api.get(`/boards/${boardId}/members`);
+    return response.data;
+  } catch (error) {
+    console.error('Ошибка загрузки участников доски:', error);
+    throw error;
+  }
+};
+
 // Task API
 export const updateTaskState = async (taskId: string, newColumnId: string): Promise<void> => {
   try {
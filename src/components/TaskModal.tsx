@@ -3,9 +3,8 @@ import { useForm } from 'react-hook-form';
 import { X } from 'lucide-react';
 import { createTask } from '../api';
 import { Task } from '../types';
-import { fetchUsers } from '../api';
+import { fetchBoardMembers } from '../api';
 import { useEffect } from 'react';
-import { User } from '../types';
 
 interface TaskModalProps {
   isOpen: boolean;
@@ -26,13 +25,13 @@ const TaskModal: React.FC<TaskModalProps> = ({ isOpen, onClose, onTaskCreated, c
   const { register, handleSubmit, formState: { errors } } = useForm<TaskFormData>();
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState<string | null>(null);
-  const [users, setUsers] = useState<User[]>([]);
+  const [users, setUsers] = useState<{ id: string; username: string }[]>([]);
 
   useEffect(() => {
     const loadUsers = async () => {
       try {
-        const usersData = await fetchUsers();
-        setUsers(usersData.filter(user => user.role !== 'admin'));
+        const members = await fetchBoardMembers();
+        setUsers(members.map(m => ({ id: m.userId, username: m.username })));
       } catch (err) {
         console.error('Ошибка загрузки пользователей:', err);
       }
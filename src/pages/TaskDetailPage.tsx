@@ -3,7 +3,7 @@ import { useParams, useNavigate } from 'react-router-dom';
 import Navbar from '../components/Navbar';
 import Sidebar from '../components/Sidebar';
 import { Task } from '../types';
-import { fetchBoardData, updateTask, deleteTask, addComment, fetchUsers } from '../api';
+import { fetchBoardData, updateTask, deleteTask, addComment, fetchBoardMembers } from '../api';
 import { useAuth } from '../context/AuthContext';
 import { AlertTriangle, MessageSquare, Trash2, Edit, ArrowLeft } from 'lucide-react';
 
@@ -51,8 +51,8 @@ const TaskDetailPage: React.FC = () => {
         
         // Get users for assignee dropdown
         try {
-          const usersData = await fetchUsers();
-          setUsers(usersData.map(u => ({ id: u.id, username: u.username })));
+          const members = await fetchBoardMembers();
+          setUsers(members.map(m => ({ id: m.userId, username: m.username })));
         } catch (err) {
           console.error('Ошибка загрузки пользователей:', err);
         }
@@ -20,12 +20,28 @@ export interface Task {
   state: string;
   priority: number;
   assignee?: string;
+  assignees?: TaskAssignee[];
+  groupId?: string;
+  group?: string;
   comments?: Comment[];
   attachments?: number | { id: string }[] | string[];
   createdAt: string;
   updatedAt: string;
 }
 
+export interface TaskAssignee {
+  id: string;
+  username: string;
+}
+
+export interface Group {
+  id: string;
+  name: string;
+  description: string;
+  memberCount: number;
+  createdAt: string;
+}
+
 export interface Comment {
   id: string;
   content: string;
@@ -45,6 +61,14 @@ export interface Board {
   columnOrder: string[];
 }
 
+export interface BoardMember {
+  userId: string;
+  username: string;
+  email: string;
+  role: 'owner' | 'editor' | 'commenter' | 'viewer';
+  joinedAt: string;
+}
+
 export interface Notification {
   id: string;
   userId: string;